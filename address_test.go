@@ -0,0 +1,72 @@
+package stun
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestAddressAttributes(t *testing.T) {
+	attributes := []struct {
+		attributeType AttributeType
+		set           func(message *Message, addr *net.UDPAddr)
+		get           func(message *Message) *net.UDPAddr
+	}{
+		{MappedAddress, (*Message).SetMappedAddress, (*Message).GetMappedAddress},
+		{XorMappedAddress, (*Message).SetXorMappedAddress, (*Message).GetXorMappedAddress},
+		{ResponseAddress, (*Message).SetResponseAddress, (*Message).GetResponseAddress},
+		{SourceAddress, (*Message).SetSourceAddress, (*Message).GetSourceAddress},
+		{ChangedAddress, (*Message).SetChangedAddress, (*Message).GetChangedAddress},
+		{ResponseOrigin, (*Message).SetResponseOrigin, (*Message).GetResponseOrigin},
+		{OtherAddress, (*Message).SetOtherAddress, (*Message).GetOtherAddress},
+	}
+	addrs := []struct {
+		addr   *net.UDPAddr
+		family byte
+	}{
+		{&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 32853}, FamilyIPv4},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8:1234:5678:11:2233:4455:6677"), Port: 32853}, FamilyIPv6},
+	}
+	for _, attribute := range attributes {
+		for _, addr := range addrs {
+			sent := NewStunMessage2(BindingResponse, nil)
+			attribute.set(sent, addr.addr)
+			value, ok := sent.GetAttribute(attribute.attributeType)
+			if !ok {
+				t.Fatalf("%v %v: not set", attribute.attributeType, addr.addr)
+			}
+			if value.Value[1] != addr.family {
+				t.Errorf("%v %v: family 0x%02x, want 0x%02x", attribute.attributeType, addr.addr, value.Value[1], addr.family)
+			}
+
+			received := NewStunMessage()
+			if err := received.Parse(sent.ToByteData()); err != nil {
+				t.Fatalf("%v %v: Parse() = %v", attribute.attributeType, addr.addr, err)
+			}
+			got := attribute.get(received)
+			if got == nil || !sameAddr(got, addr.addr) {
+				t.Errorf("%v: got %v, want %v", attribute.attributeType, got, addr.addr)
+			}
+		}
+	}
+}
+
+func TestInvalidAddress(t *testing.T) {
+	for _, addr := range []*net.UDPAddr{nil, {Port: 1}, {IP: net.IP{192, 0, 2}, Port: 1}} {
+		if _, err := Build(BindingResponse, WithMappedAddress(addr)); !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("Build(WithMappedAddress(%v)) = %v, want %v", addr, err, ErrInvalidAddress)
+		}
+
+		// The setters leave the attribute out rather than encode it.
+		message := NewStunMessage2(BindingResponse, nil)
+		message.SetMappedAddress(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1})
+		message.SetMappedAddress(addr)
+		message.SetXorMappedAddress(addr)
+		if attributes := message.GetAttributes(); len(attributes) != 0 {
+			t.Errorf("%v: got attributes %v", addr, attributes)
+		}
+		if err := NewStunMessage().Parse(message.ToByteData()); err != nil {
+			t.Errorf("%v: Parse() = %v", addr, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package stun
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A packet received by an Agent for one of its transactions, copied out of
+// the read buffer so that the response parsed from it may keep referring to it.
+type agentPacket struct {
+	data []byte
+	from net.Addr
+}
+
+// Owns the read loop of a socket and dispatches the STUN messages it
+// receives to the transactions waiting for them, by transaction ID, so that
+// many transactions can run concurrently on one socket. Other packets go to
+// the unhandled callback, if any, so that the socket can be shared with
+// other protocols.
+type Agent struct {
+	socket    *net.UDPConn
+	unhandled func(packet []byte, from net.Addr)
+
+	mutex        sync.Mutex
+	transactions map[[12]byte]chan agentPacket
+	closed       bool
+
+	// Closed once the read loop is over, err then tells why.
+	done chan struct{}
+	err  error
+}
+
+// Starts reading socket. unhandled, if not nil, is called from the read loop
+// with every packet no transaction waits for; packet is only valid until it
+// returns. Close the agent before the socket.
+//
+// Until Close returns, the agent is the only reader of socket and owns its
+// read deadline: do not read from socket or set its read deadline, packets for
+// other protocols arrive through unhandled. NewAgent clears the read deadline,
+// and socket is left without one after Close. Writing to socket is fine.
+func NewAgent(socket *net.UDPConn, unhandled func(packet []byte, from net.Addr)) *Agent {
+	_ = socket.SetReadDeadline(time.Time{})
+	agent := &Agent{
+		socket:       socket,
+		unhandled:    unhandled,
+		transactions: make(map[[12]byte]chan agentPacket),
+		done:         make(chan struct{}),
+	}
+	go agent.readLoop()
+	return agent
+}
+
+func (agent *Agent) LocalAddr() net.Addr {
+	return agent.socket.LocalAddr()
+}
+
+// Stops the read loop and fails the transactions still waiting. The socket
+// is left open, without a read deadline.
+func (agent *Agent) Close() error {
+	agent.mutex.Lock()
+	if agent.closed {
+		agent.mutex.Unlock()
+		return ErrAgentClosed
+	}
+	agent.closed = true
+	_ = agent.socket.SetReadDeadline(time.Unix(1, 0))
+	agent.mutex.Unlock()
+
+	<-agent.done
+	_ = agent.socket.SetReadDeadline(time.Time{})
+	return nil
+}
+
+func (agent *Agent) readLoop() {
+	defer close(agent.done)
+	buffer := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(buffer)
+	message := AcquireMessage()
+	defer ReleaseMessage(message)
+
+	for {
+		n, from, err := agent.socket.ReadFrom(*buffer)
+		if err != nil {
+			if agent.stopReading(err) {
+				return
+			}
+			continue
+		}
+		packet := (*buffer)[:n]
+		if message.Decode(packet) == nil {
+			if transaction := agent.transaction(message.transactionId); transaction != nil {
+				// Only the first packet of a transaction is queued, the
+				// others are retransmitted responses.
+				select {
+				case transaction <- agentPacket{data: append([]byte(nil), packet...), from: from}:
+				default:
+				}
+				continue
+			}
+		}
+		if agent.unhandled != nil {
+			agent.unhandled(packet, from)
+		}
+	}
+}
+
+// Tells whether the read loop must stop after err, and sets agent.err if so.
+func (agent *Agent) stopReading(err error) bool {
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+	if agent.closed {
+		agent.err = ErrAgentClosed
+		return true
+	}
+	// ICMP errors for packets sent earlier, and oversized datagrams, only
+	// concern one packet. Anything else, including a timeout, which means
+	// someone set a read deadline against the contract of NewAgent, stops the
+	// loop.
+	if isTransientReadError(err) {
+		return false
+	}
+	agent.err = err
+	return true
+}
+
+func isTransientReadError(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EMSGSIZE)
+}
+
+func (agent *Agent) transaction(transactionId []byte) chan agentPacket {
+	var key [12]byte
+	copy(key[:], transactionId)
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+	return agent.transactions[key]
+}
+
+// Registers a transaction; its packets arrive on the returned channel until
+// stop is called.
+func (agent *Agent) start(transactionId []byte) (packets chan agentPacket, stop func(), err error) {
+	var key [12]byte
+	copy(key[:], transactionId)
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+	if agent.closed {
+		return nil, nil, ErrAgentClosed
+	}
+	if _, ok := agent.transactions[key]; ok {
+		return nil, nil, ErrDuplicateTransaction
+	}
+	packets = make(chan agentPacket, 1)
+	agent.transactions[key] = packets
+	return packets, func() {
+		agent.mutex.Lock()
+		defer agent.mutex.Unlock()
+		delete(agent.transactions, key)
+	}, nil
+}
@@ -0,0 +1,155 @@
+package stun
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func listenLoopback(t *testing.T) *net.UDPConn {
+	t.Helper()
+	socket, err := net.ListenUDP("udp4", &net.UDPAddr{IP: fakePrimaryIp})
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { socket.Close() })
+	return socket
+}
+
+func TestAgentConcurrentTransactions(t *testing.T) {
+	server := startFakeServer(t, &fakeServer{})
+	socket := listenLoopback(t)
+	client := newFakeClient(server, WithSocket(socket))
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request, err := Build(BindingRequest)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			response, err := client.Do(context.Background(), request)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if response == nil {
+				t.Error("no response")
+				return
+			}
+			if !bytes.Equal(response.GetTransactionId(), request.GetTransactionId()) {
+				t.Errorf("got the response to %x for %x", response.GetTransactionId(), request.GetTransactionId())
+			}
+			if mapped := response.getPublicAddress(); mapped == nil || !sameAddr(mapped, socket.LocalAddr().(*net.UDPAddr)) {
+				t.Errorf("got mapped address %v, want %v", mapped, socket.LocalAddr())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAgentUnhandled(t *testing.T) {
+	socket := listenLoopback(t)
+	type received struct {
+		packet []byte
+		from   string
+	}
+	unhandled := make(chan received, 2)
+	agent := NewAgent(socket, func(packet []byte, from net.Addr) {
+		unhandled <- received{append([]byte(nil), packet...), from.String()}
+	})
+	defer agent.Close()
+
+	// A STUN message no transaction waits for, and a packet of another
+	// protocol.
+	stray, err := Build(BindingResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := [][]byte{stray.ToByteData(), []byte("not a STUN message")}
+	peer := listenLoopback(t)
+	for _, packet := range packets {
+		if _, err := peer.WriteTo(packet, socket.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, packet := range packets {
+		select {
+		case got := <-unhandled:
+			if !bytes.Equal(got.packet, packet) {
+				t.Errorf("got %x, want %x", got.packet, packet)
+			}
+			if got.from != peer.LocalAddr().String() {
+				t.Errorf("got a packet from %v, want %v", got.from, peer.LocalAddr())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%x did not reach unhandled", packet)
+		}
+	}
+}
+
+func TestAgentCloseFailsTransactions(t *testing.T) {
+	server := startFakeServer(t, &fakeServer{drop: func(request *Message, on *net.UDPAddr) bool {
+		return true
+	}})
+	agent := NewAgent(listenLoopback(t), nil)
+	client := newFakeClient(server, WithAgent(agent), WithTimeout(time.Minute))
+
+	errs := make(chan error, 1)
+	go func() {
+		request, err := Build(BindingRequest)
+		if err == nil {
+			_, err = client.Do(context.Background(), request)
+		}
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := agent.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrAgentClosed) {
+			t.Errorf("got %v, want %v", err, ErrAgentClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the transaction outlived the agent")
+	}
+	if err := agent.Close(); !errors.Is(err, ErrAgentClosed) {
+		t.Errorf("closed twice: got %v, want %v", err, ErrAgentClosed)
+	}
+}
+
+func TestAgentSurvivesTransientReadErrors(t *testing.T) {
+	for _, errno := range []syscall.Errno{syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.EMSGSIZE} {
+		err := &net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", errno)}
+		if !isTransientReadError(err) {
+			t.Errorf("%v stops the read loop", err)
+		}
+	}
+
+	// A read deadline set behind the agent's back stops it.
+	socket := listenLoopback(t)
+	agent := NewAgent(socket, nil)
+	if err := socket.SetReadDeadline(time.Unix(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-agent.done:
+		if netErr, ok := agent.err.(net.Error); !ok || !netErr.Timeout() {
+			t.Errorf("stopped with %v, want a timeout", agent.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the agent survived a foreign read deadline")
+	}
+}
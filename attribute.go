@@ -0,0 +1,112 @@
+package stun
+
+// A raw STUN attribute as it appears on the wire, without the padding.
+type Attribute struct {
+	Type  AttributeType
+	Value []byte
+
+	// The padding bytes seen by Parse, so that a parsed message re-encodes
+	// byte-for-byte. Zero bytes are written when it does not fit the value.
+	padding []byte
+}
+
+// RFC 5389 15: attributes are padded to a multiple of 4 bytes.
+func paddingLength(length int) int {
+	return (4 - length%4) % 4
+}
+
+// Checks that the value of a known attribute can be decoded, so that the
+// typed accessors never index past it.
+func validateAttribute(attribute Attribute) error {
+	switch attribute.Type {
+	case MappedAddress, ResponseAddress, SourceAddress, ChangedAddress, ReflectedFrom, XorMappedAddress,
+		ResponseOrigin, OtherAddress:
+		if len(attribute.Value) < 4 {
+			return ErrInvalidAttributeLength
+		}
+		switch attribute.Value[1] {
+		case FamilyIPv4:
+			if len(attribute.Value) != 4+4 {
+				return ErrInvalidAttributeLength
+			}
+		case FamilyIPv6:
+			if len(attribute.Value) != 4+16 {
+				return ErrInvalidAttributeLength
+			}
+		default:
+			return ErrInvalidAddressFamily
+		}
+	case ChangeRequest, Fingerprint, ResponsePort:
+		if len(attribute.Value) != 4 {
+			return ErrInvalidAttributeLength
+		}
+	case MessageIntegrity:
+		if len(attribute.Value) != 20 {
+			return ErrInvalidAttributeLength
+		}
+	case ErrorCode:
+		if len(attribute.Value) < 4 {
+			return ErrInvalidAttributeLength
+		}
+		// The class is the hundreds digit, the number the rest.
+		if class := attribute.Value[2] & 0x7; class < 3 || class > 6 || attribute.Value[3] > 99 {
+			return ErrInvalidErrorCode
+		}
+	case UnknownAttribute:
+		if len(attribute.Value)%2 != 0 {
+			return ErrInvalidAttributeLength
+		}
+	case Username, Realm, Nonce, Software:
+		if len(attribute.Value) > stringAttributeLimits[attribute.Type].bytes {
+			return ErrInvalidAttributeLength
+		}
+	}
+	return nil
+}
+
+// Returns all attributes of the message in the order they appear on the wire.
+func (message *Message) GetAttributes() []Attribute {
+	return message.attributes
+}
+
+// Returns the first attribute of the given type.
+func (message *Message) GetAttribute(attributeType AttributeType) (Attribute, bool) {
+	for _, attribute := range message.attributes {
+		if attribute.Type == attributeType {
+			return attribute, true
+		}
+	}
+	return Attribute{}, false
+}
+
+// Appends an attribute, even if the message already carries one of the same type.
+func (message *Message) AddAttribute(attributeType AttributeType, value []byte) {
+	message.attributes = append(message.attributes, Attribute{
+		Type:  attributeType,
+		Value: value,
+	})
+}
+
+// Replaces the value of the first attribute of the given type, or appends
+// the attribute if the message does not carry one yet.
+func (message *Message) SetAttribute(attributeType AttributeType, value []byte) {
+	for i := range message.attributes {
+		if message.attributes[i].Type == attributeType {
+			message.attributes[i].Value = value
+			message.attributes[i].padding = nil
+			return
+		}
+	}
+	message.AddAttribute(attributeType, value)
+}
+
+// Removes every attribute of the given type.
+func (message *Message) RemoveAttribute(attributeType AttributeType) {
+	attributes := message.attributes[:0]
+	for _, attribute := range message.attributes {
+		if attribute.Type != attributeType {
+			attributes = append(attributes, attribute)
+		}
+	}
+	message.attributes = attributes
+}
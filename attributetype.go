@@ -1,46 +1,63 @@
 package stun
 
+import "fmt"
+
 type AttributeType uint
 
 const (
-	Undefined AttributeType = iota
-	MappedAddress
-	ResponseAddress
-	ChangeRequest
-	SourceAddress
-	ChangedAddress
-	Username
-	Password
-	MessageIntegrity
-	ErrorCode
-	UnknownAttribute
-	ReflectedFrom
-	XorMappedAddress
-	XorOnly
-	ServerName
+	Undefined        AttributeType = 0x0000
+	MappedAddress    AttributeType = 0x0001
+	ResponseAddress  AttributeType = 0x0002
+	ChangeRequest    AttributeType = 0x0003
+	SourceAddress    AttributeType = 0x0004
+	ChangedAddress   AttributeType = 0x0005
+	Username         AttributeType = 0x0006
+	Password         AttributeType = 0x0007
+	MessageIntegrity AttributeType = 0x0008
+	ErrorCode        AttributeType = 0x0009
+	UnknownAttribute AttributeType = 0x000A
+	ReflectedFrom    AttributeType = 0x000B
+	Realm            AttributeType = 0x0014
+	Nonce            AttributeType = 0x0015
+	XorMappedAddress AttributeType = 0x0020
+	XorOnly          AttributeType = 0x0021
+	ResponsePort     AttributeType = 0x0027
+	Software         AttributeType = 0x8022
+	Fingerprint      AttributeType = 0x8028
+	ResponseOrigin   AttributeType = 0x802B
+	OtherAddress     AttributeType = 0x802C
+
+	// The name RFC 3489 drafts used for SOFTWARE.
+	ServerName = Software
 )
 
-var attributeTypeNames = []string{
-	"Undefined",
-	"MappedAddress",
-	"ResponseAddress",
-	"ChangeRequest",
-	"SourceAddress",
-	"ChangedAddress",
-	"Username",
-	"Password",
-	"MessageIntegrity",
-	"ErrorCode",
-	"UnknownAttribute",
-	"ReflectedFrom",
-	"XorMappedAddress",
-	"XorOnly",
-	"ServerName",
+var attributeTypeNames = map[AttributeType]string{
+	Undefined:        "Undefined",
+	MappedAddress:    "MappedAddress",
+	ResponseAddress:  "ResponseAddress",
+	ChangeRequest:    "ChangeRequest",
+	SourceAddress:    "SourceAddress",
+	ChangedAddress:   "ChangedAddress",
+	Username:         "Username",
+	Password:         "Password",
+	MessageIntegrity: "MessageIntegrity",
+	ErrorCode:        "ErrorCode",
+	UnknownAttribute: "UnknownAttribute",
+	ReflectedFrom:    "ReflectedFrom",
+	Realm:            "Realm",
+	Nonce:            "Nonce",
+	XorMappedAddress: "XorMappedAddress",
+	XorOnly:          "XorOnly",
+	ResponsePort:     "ResponsePort",
+	Software:         "Software",
+	Fingerprint:      "Fingerprint",
+	ResponseOrigin:   "ResponseOrigin",
+	OtherAddress:     "OtherAddress",
 }
 
 func (t AttributeType) String() string {
-	if t <= ServerName {
-		return attributeTypeNames[t]
+	if name, ok := attributeTypeNames[t]; ok {
+		return name
 	}
-	return ""
+	return fmt.Sprintf("0x%04X", uint(t))
 }
@@ -0,0 +1,92 @@
+package stun
+
+// RFC 5780 4.3: how the NAT maps an internal address and port to external ones.
+type MappingBehavior uint
+
+const (
+	/// No NAT, the mapped address is the local address.
+	NoNatMapping MappingBehavior = iota
+
+	/// The same mapping is reused for all destinations.
+	EndpointIndependentMapping
+
+	/// The mapping is reused for destinations with the same IP address, whatever the port.
+	AddressDependentMapping
+
+	/// The mapping is only reused for the same destination IP address and port.
+	AddressAndPortDependentMapping
+
+	MappingUnknown
+)
+
+var mappingBehaviorNames = []string{
+	"NoNatMapping",
+	"EndpointIndependentMapping",
+	"AddressDependentMapping",
+	"AddressAndPortDependentMapping",
+	"MappingUnknown",
+}
+
+func (behavior MappingBehavior) String() string {
+	if behavior <= MappingUnknown {
+		return mappingBehaviorNames[behavior]
+	}
+	return ""
+}
+
+// RFC 5780 4.4: which external endpoints may send packets to a mapping.
+type FilteringBehavior uint
+
+const (
+	/// Any external endpoint may send packets to the mapping.
+	EndpointIndependentFiltering FilteringBehavior = iota
+
+	/// Only IP addresses the internal endpoint has sent packets to, from any port.
+	AddressDependentFiltering
+
+	/// Only IP addresses and ports the internal endpoint has sent packets to.
+	AddressAndPortDependentFiltering
+
+	FilteringUnknown
+)
+
+var filteringBehaviorNames = []string{
+	"EndpointIndependentFiltering",
+	"AddressDependentFiltering",
+	"AddressAndPortDependentFiltering",
+	"FilteringUnknown",
+}
+
+func (behavior FilteringBehavior) String() string {
+	if behavior <= FilteringUnknown {
+		return filteringBehaviorNames[behavior]
+	}
+	return ""
+}
+
+// RFC 5780 4.5: whether the NAT loops packets sent to one of its own mappings
+// back to the internal endpoint, so two peers behind it can reach each other.
+type HairpinningBehavior uint
+
+const (
+	/// Packets to a mapping from behind the NAT reach its internal endpoint.
+	Hairpinning HairpinningBehavior = iota
+
+	/// Packets to a mapping from behind the NAT are dropped.
+	NoHairpinning
+
+	HairpinningUnknown
+)
+
+var hairpinningBehaviorNames = []string{
+	"Hairpinning",
+	"NoHairpinning",
+	"HairpinningUnknown",
+}
+
+func (behavior HairpinningBehavior) String() string {
+	if behavior <= HairpinningUnknown {
+		return hairpinningBehaviorNames[behavior]
+	}
+	return ""
+}
@@ -0,0 +1,255 @@
+package stun
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+)
+
+type messageBuilder struct {
+	messageType   MessageType
+	random        io.Reader
+	transactionId []byte
+	integrityKey  []byte
+	fingerprint   bool
+	types         map[AttributeType]bool
+	setters       []func(message *Message) error
+}
+
+// Configures a message built by Build.
+type MessageOption func(builder *messageBuilder) error
+
+// Builds a message of the given type, e.g.
+//
+//	stun.Build(stun.BindingRequest, stun.WithChangeRequest(true, true), stun.WithSoftware("nat-type"), stun.WithFingerprint())
+//
+// Attributes are added in the order of the options, followed by
+// MESSAGE-INTEGRITY and FINGERPRINT whatever their position. Build fails if
+// an attribute is given twice or does not belong in a message of that class.
+func Build(messageType MessageType, opts ...MessageOption) (*Message, error) {
+	builder := &messageBuilder{
+		messageType: messageType,
+		random:      rand.Reader,
+		types:       make(map[AttributeType]bool),
+	}
+	for _, opt := range opts {
+		if err := opt(builder); err != nil {
+			return nil, err
+		}
+	}
+	if err := builder.validate(); err != nil {
+		return nil, err
+	}
+
+	message := &Message{
+		messageType: messageType,
+		magicCookie: MagicCookie,
+	}
+	if builder.transactionId != nil {
+		copy(message.transactionIdBuffer[:], builder.transactionId)
+		message.transactionId = message.transactionIdBuffer[:]
+	} else if err := message.NewTransactionId(builder.random); err != nil {
+		return nil, err
+	}
+	// The transaction ID is known now, which XOR-MAPPED-ADDRESS depends on.
+	for _, setter := range builder.setters {
+		if err := setter(message); err != nil {
+			return nil, err
+		}
+	}
+	if builder.integrityKey != nil {
+		message.AddMessageIntegrity(builder.integrityKey)
+	}
+	if builder.fingerprint {
+		message.AddFingerprint()
+	}
+	return message, nil
+}
+
+// Registers an attribute, rejecting duplicates and attributes that do not
+// belong in a message of the builder's class.
+func (builder *messageBuilder) add(attributeType AttributeType, setter func(message *Message) error) error {
+	if builder.types[attributeType] {
+		return fmt.Errorf("%s: %w", attributeType, ErrDuplicateAttribute)
+	}
+	class := builder.messageType.Class()
+	allowed := true
+	switch attributeType {
+	case ChangeRequest, ResponseAddress, ResponsePort:
+		allowed = class == ClassRequest
+	case MappedAddress, XorMappedAddress, SourceAddress, ChangedAddress, ReflectedFrom, ResponseOrigin, OtherAddress:
+		allowed = class == ClassSuccessResponse
+	case ErrorCode, UnknownAttribute:
+		allowed = class == ClassErrorResponse
+	}
+	if !allowed {
+		return fmt.Errorf("%s in %s: %w", attributeType, builder.messageType, ErrAttributeNotAllowed)
+	}
+	builder.types[attributeType] = true
+	builder.setters = append(builder.setters, setter)
+	return nil
+}
+
+func (builder *messageBuilder) validate() error {
+	if builder.messageType.Class() == ClassErrorResponse && !builder.types[ErrorCode] {
+		return fmt.Errorf("%s: %w", builder.messageType, ErrMissingErrorCode)
+	}
+	// RFC 5389 10.2.2: long-term credentials come with USERNAME, REALM and NONCE.
+	if builder.integrityKey != nil && builder.messageType.Class() == ClassRequest {
+		if builder.types[Realm] && !(builder.types[Username] && builder.types[Nonce]) {
+			return fmt.Errorf("%s: %w", builder.messageType, ErrMissingCredentials)
+		}
+	}
+	return nil
+}
+
+// Uses transactionId, which must be 12 bytes, instead of a random one.
+func WithTransactionId(transactionId []byte) MessageOption {
+	return func(builder *messageBuilder) error {
+		if len(transactionId) != 12 {
+			return ErrInvalidTransactionId
+		}
+		builder.transactionId = transactionId
+		return nil
+	}
+}
+
+// Reads the transaction ID from random instead of crypto/rand.
+func WithTransactionIdFrom(random io.Reader) MessageOption {
+	return func(builder *messageBuilder) error {
+		builder.random = random
+		return nil
+	}
+}
+
+func WithChangeRequest(changeIp bool, changePort bool) MessageOption {
+	return func(builder *messageBuilder) error {
+		return builder.add(ChangeRequest, func(message *Message) error {
+			message.SetChangeRequest(NewStunChangeRequest(changeIp, changePort))
+			return nil
+		})
+	}
+}
+
+func withAddress(attributeType AttributeType, addr *net.UDPAddr, set func(message *Message, addr *net.UDPAddr)) MessageOption {
+	return func(builder *messageBuilder) error {
+		if addr == nil || addr.IP.To16() == nil {
+			return fmt.Errorf("%s %v: %w", attributeType, addr, ErrInvalidAddress)
+		}
+		return builder.add(attributeType, func(message *Message) error {
+			set(message, addr)
+			return nil
+		})
+	}
+}
+
+func WithMappedAddress(addr *net.UDPAddr) MessageOption {
+	return withAddress(MappedAddress, addr, (*Message).SetMappedAddress)
+}
+
+func WithXorMappedAddress(addr *net.UDPAddr) MessageOption {
+	return withAddress(XorMappedAddress, addr, (*Message).SetXorMappedAddress)
+}
+
+func WithResponseAddress(addr *net.UDPAddr) MessageOption {
+	return withAddress(ResponseAddress, addr, (*Message).SetResponseAddress)
+}
+
+func WithSourceAddress(addr *net.UDPAddr) MessageOption {
+	return withAddress(SourceAddress, addr, (*Message).SetSourceAddress)
+}
+
+func WithChangedAddress(addr *net.UDPAddr) MessageOption {
+	return withAddress(ChangedAddress, addr, (*Message).SetChangedAddress)
+}
+
+func WithResponseOrigin(addr *net.UDPAddr) MessageOption {
+	return withAddress(ResponseOrigin, addr, (*Message).SetResponseOrigin)
+}
+
+func WithOtherAddress(addr *net.UDPAddr) MessageOption {
+	return withAddress(OtherAddress, addr, (*Message).SetOtherAddress)
+}
+
+func WithResponsePort(port int) MessageOption {
+	return func(builder *messageBuilder) error {
+		return builder.add(ResponsePort, func(message *Message) error {
+			message.SetResponsePort(port)
+			return nil
+		})
+	}
+}
+
+func WithErrorCode(errorCode *Code) MessageOption {
+	return func(builder *messageBuilder) error {
+		return builder.add(ErrorCode, func(message *Message) error {
+			return message.SetErrorCode(errorCode)
+		})
+	}
+}
+
+func WithUnknownAttributes(types ...AttributeType) MessageOption {
+	return func(builder *messageBuilder) error {
+		return builder.add(UnknownAttribute, func(message *Message) error {
+			message.SetUnknownAttributes(types)
+			return nil
+		})
+	}
+}
+
+func withString(attributeType AttributeType, value string) MessageOption {
+	return func(builder *messageBuilder) error {
+		return builder.add(attributeType, func(message *Message) error {
+			return message.setString(attributeType, value)
+		})
+	}
+}
+
+func WithUsername(username string) MessageOption {
+	return withString(Username, username)
+}
+
+func WithRealm(realm string) MessageOption {
+	return withString(Realm, realm)
+}
+
+func WithNonce(nonce string) MessageOption {
+	return withString(Nonce, nonce)
+}
+
+func WithSoftware(software string) MessageOption {
+	return withString(Software, software)
+}
+
+// Adds an arbitrary attribute, e.g. one this package does not model.
+// MESSAGE-INTEGRITY and FINGERPRINT are computed over the finished message, so
+// they are rejected: use WithMessageIntegrity and WithFingerprint.
+func WithAttribute(attributeType AttributeType, value []byte) MessageOption {
+	return func(builder *messageBuilder) error {
+		if attributeType == MessageIntegrity || attributeType == Fingerprint {
+			return fmt.Errorf("%s in WithAttribute: %w", attributeType, ErrAttributeNotAllowed)
+		}
+		return builder.add(attributeType, func(message *Message) error {
+			message.AddAttribute(attributeType, value)
+			return nil
+		})
+	}
+}
+
+// Adds MESSAGE-INTEGRITY computed with key after all other attributes,
+// except FINGERPRINT. See ShortTermKey and LongTermKey.
+func WithMessageIntegrity(key []byte) MessageOption {
+	return func(builder *messageBuilder) error {
+		builder.integrityKey = key
+		return nil
+	}
+}
+
+// Adds FINGERPRINT as the last attribute.
+func WithFingerprint() MessageOption {
+	return func(builder *messageBuilder) error {
+		builder.fingerprint = true
+		return nil
+	}
+}
@@ -0,0 +1,43 @@
+package stun
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestBuildValidation(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 32853}
+	key := ShortTermKey("password")
+	tests := []struct {
+		name        string
+		messageType MessageType
+		opts        []MessageOption
+		err         error
+	}{
+		{"valid request", BindingRequest, []MessageOption{WithChangeRequest(true, true), WithSoftware("nat-type"), WithFingerprint()}, nil},
+		{"valid response", BindingResponse, []MessageOption{WithXorMappedAddress(addr), WithOtherAddress(addr)}, nil},
+		{"valid error response", BindingErrorResponse, []MessageOption{WithErrorCode(CodeUnknownAttribute), WithUnknownAttributes(0x0030)}, nil},
+		{"long-term credentials", BindingRequest, []MessageOption{WithUsername("user"), WithRealm("realm"), WithNonce("nonce"), WithMessageIntegrity(key)}, nil},
+		{"duplicate", BindingRequest, []MessageOption{WithSoftware("a"), WithSoftware("b")}, ErrDuplicateAttribute},
+		{"duplicate through WithAttribute", BindingRequest, []MessageOption{WithSoftware("a"), WithAttribute(Software, []byte("b"))}, ErrDuplicateAttribute},
+		{"CHANGE-REQUEST in a response", BindingResponse, []MessageOption{WithChangeRequest(true, false)}, ErrAttributeNotAllowed},
+		{"mapped address in a request", BindingRequest, []MessageOption{WithMappedAddress(addr)}, ErrAttributeNotAllowed},
+		{"ERROR-CODE in a success response", BindingResponse, []MessageOption{WithErrorCode(CodeBadRequest)}, ErrAttributeNotAllowed},
+		{"MESSAGE-INTEGRITY through WithAttribute", BindingRequest, []MessageOption{WithAttribute(MessageIntegrity, make([]byte, 20))}, ErrAttributeNotAllowed},
+		{"FINGERPRINT through WithAttribute", BindingRequest, []MessageOption{WithAttribute(Fingerprint, make([]byte, 4))}, ErrAttributeNotAllowed},
+		{"error response without ERROR-CODE", BindingErrorResponse, nil, ErrMissingErrorCode},
+		{"REALM without NONCE", BindingRequest, []MessageOption{WithUsername("user"), WithRealm("realm"), WithMessageIntegrity(key)}, ErrMissingCredentials},
+		{"REALM without USERNAME", BindingRequest, []MessageOption{WithRealm("realm"), WithNonce("nonce"), WithMessageIntegrity(key)}, ErrMissingCredentials},
+	}
+	for _, test := range tests {
+		message, err := Build(test.messageType, test.opts...)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: Build() = %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if test.err != nil && message != nil {
+			t.Errorf("%s: Build() returned a message with %v", test.name, err)
+		}
+	}
+}
@@ -28,3 +28,39 @@ func NewStunChangeRequest(changeIp bool, changePort bool) *Request {
 	}
 
 }
+
+func (request Request) toByteData() []byte {
+	/*
+	   The CHANGE-REQUEST attribute is used by the client to request that
+	   the server use a different address and/or port when sending the
+	   response.  The attribute is 32 bits long, although only two bits (A
+	   and B) are used:
+	    0                   1                   2                   3
+	    0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	   |0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 A B 0|
+	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	   The meaning of the flags is:
+	   A: This is the "change IP" flag.  If true, it requests the server
+	      to send the Binding Response with a different IP address than the
+	      one the Binding Request was received on.
+	   B: This is the "change port" flag.  If true, it requests the
+	      server to send the Binding Response with a different port than the
+	      one the Binding Request was received on.
+	*/
+	value := make([]byte, 4)
+	if request.changIp {
+		value[3] |= 4
+	}
+	if request.changePort {
+		value[3] |= 2
+	}
+	return value
+}
+
+func parseChangeRequest(value []byte) *Request {
+	if len(value) < 4 {
+		return nil
+	}
+	return NewStunChangeRequest((value[3]&4) != 0, (value[3]&2) != 0)
+}
@@ -1,51 +1,276 @@
 package stun
 
 import (
-	"bytes"
-	"errors"
+	"context"
+	"crypto/rand"
+	"io"
 	"net"
+	"sync"
 	"time"
 )
 
+// Deprecated: a Client gives up on a test after UdpSendCount times
+// TransactionTimeout milliseconds by default, see WithTimeout and
+// WithRetransmission.
 const (
 	UdpSendCount       = 3
 	TransactionTimeout = 1000
 )
 
-func getAddr(stun string, local string) (*net.UDPAddr, *net.UDPAddr, error) {
-	stunAddr, err := net.ResolveUDPAddr("udp", stun)
+// Identifies a test in the timeouts of a Client and in its log.
+type Test uint
+
+const (
+	/// Binding Request to the primary address of the server.
+	TestI Test = iota
+
+	/// "change IP" and "change port" set.
+	TestII
+
+	/// Only "change port" set.
+	TestIII
+
+	/// Test I to the alternate address of the server.
+	TestIAlternate
+
+	/// Binding Request to the alternate IP address and the primary port.
+	TestMappingII
+
+	/// Binding Request to the alternate IP address and port.
+	TestMappingIII
+
+	/// Binding Request from a second socket to the mapping of the first.
+	TestHairpinning
+
+	/// Binding Request with RESPONSE-PORT after the mapping was left idle.
+	TestLifetime
+
+	/// A request sent with Client.Do.
+	TestRequest
+)
+
+var testNames = []string{
+	"TestI",
+	"TestII",
+	"TestIII",
+	"TestIAlternate",
+	"TestMappingII",
+	"TestMappingIII",
+	"TestHairpinning",
+	"TestLifetime",
+	"TestRequest",
+}
+
+func (test Test) String() string {
+	if test <= TestRequest {
+		return testNames[test]
+	}
+	return ""
+}
+
+// Runs the tests against one STUN server. Configure it with ClientOptions;
+// the package-level functions create a Client for every call.
+type Client struct {
+	// Transport
+	serverAddr *net.UDPAddr
+	localAddr  *net.UDPAddr
+	socket     *net.UDPConn
+	agentMutex sync.Mutex
+	agent      *Agent
+	ownsAgent  bool
+
+	// Retry policy
+	rc           int
+	rm           int
+	timeout      time.Duration
+	testTimeouts map[Test]time.Duration
+
+	// RTO estimation, cached per server IP address
+	rto      time.Duration
+	rtoMutex sync.Mutex
+	rtos     map[string]*rtoEstimate
+
+	// Requests
+	random       io.Reader
+	username     string
+	integrityKey []byte
+	fingerprint  bool
+
+	// MeasureLifetime
+	lifetimeMin       time.Duration
+	lifetimeMax       time.Duration
+	lifetimePrecision time.Duration
+	lifetimeProgress  func(progress LifetimeProgress)
+
+	logf func(format string, v ...interface{})
+}
+
+// Creates a Client for the STUN server stun, whose sockets bind to local.
+func NewClient(stun string, local string, opts ...ClientOption) (*Client, error) {
+	stunAddr, localAddr, err := getAddr(stun, local)
 	if err != nil {
-		return nil, nil, errors.New("stun is invalid")
+		return nil, err
+	}
+	return NewClient2(stunAddr, localAddr, opts...), nil
+}
+
+func NewClient2(stunAddr *net.UDPAddr, localAddr *net.UDPAddr, opts ...ClientOption) *Client {
+	client := &Client{
+		serverAddr:        stunAddr,
+		localAddr:         localAddr,
+		rc:                defaultRc,
+		rm:                defaultRm,
+		timeout:           UdpSendCount * TransactionTimeout * time.Millisecond,
+		testTimeouts:      map[Test]time.Duration{},
+		rto:               defaultRto,
+		rtos:              map[string]*rtoEstimate{},
+		random:            rand.Reader,
+		lifetimeMin:       5 * time.Second,
+		lifetimeMax:       10 * time.Minute,
+		lifetimePrecision: 5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	return client
+}
+
+// Stops the Agent the client started on the socket set with WithSocket. The
+// socket is left open.
+func (client *Client) Close() error {
+	client.agentMutex.Lock()
+	defer client.agentMutex.Unlock()
+	if !client.ownsAgent {
+		return nil
+	}
+	client.ownsAgent = false
+	agent := client.agent
+	client.agent = nil
+	return agent.Close()
+}
+
+// Returns the agent of the client, or one on a new socket bound to the local
+// address that release closes.
+func (client *Client) listen() (agent *Agent, release func(), err error) {
+	client.agentMutex.Lock()
+	defer client.agentMutex.Unlock()
+	if client.agent != nil {
+		return client.agent, func() {}, nil
+	}
+	if client.socket != nil {
+		client.agent = NewAgent(client.socket, nil)
+		client.ownsAgent = true
+		return client.agent, func() {}, nil
 	}
+	return listenAgent(client.localAddr)
+}
+
+// Opens a second socket on the local IP address, with any port.
+func (client *Client) listenAnyPort() (agent *Agent, release func(), err error) {
+	return listenAgent(&net.UDPAddr{IP: client.localAddr.IP, Zone: client.localAddr.Zone})
+}
+
+func listenAgent(localAddr *net.UDPAddr) (agent *Agent, release func(), err error) {
+	socket, err := net.ListenUDP(udpNetwork(localAddr), localAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	agent = NewAgent(socket, nil)
+	return agent, func() {
+		agent.Close()
+		socket.Close()
+	}, nil
+}
+
+// How long to wait in total for a response to test, 0 for the whole
+// retransmission schedule.
+func (client *Client) testTimeout(test Test) time.Duration {
+	if timeout, ok := client.testTimeouts[test]; ok {
+		return timeout
+	}
+	return client.timeout
+}
+
+func (client *Client) log(format string, v ...interface{}) {
+	if client.logf != nil {
+		client.logf(format, v...)
+	}
+}
+
+// Returns the network matching the family of the local address, so that the
+// STUN server is resolved to an address we can reach from it.
+func udpNetwork(localAddr *net.UDPAddr) string {
+	if localAddr.IP == nil {
+		return "udp"
+	}
+	if localAddr.IP.To4() != nil {
+		return "udp4"
+	}
+	return "udp6"
+}
+
+func getAddr(stun string, local string) (*net.UDPAddr, *net.UDPAddr, error) {
 	localAddr, err := net.ResolveUDPAddr("udp", local)
 	if err != nil {
-		return stunAddr, nil, errors.New("local is invalid")
+		return nil, nil, &ResolveError{Address: local, Local: true, Err: err}
+	}
+	stunAddr, err := net.ResolveUDPAddr(udpNetwork(localAddr), stun)
+	if err != nil {
+		return nil, localAddr, &ResolveError{Address: stun, Err: err}
 	}
 	return stunAddr, localAddr, nil
 }
 
-func Query(stun string, local string) (*Result, error) {
-	stunAddr, localAddr, err := getAddr(stun, local)
+func Query(stun string, local string, opts ...ClientOption) (*Result, error) {
+	return QueryContext(context.Background(), stun, local, opts...)
+}
+
+func Query1(stun string, socket *net.UDPConn, local string, opts ...ClientOption) (*Result, error) {
+	return Query1Context(context.Background(), stun, socket, local, opts...)
+}
+
+func Query2(stunAddr *net.UDPAddr, socket *net.UDPConn, localAddr *net.UDPAddr, opts ...ClientOption) (*Result, error) {
+	return Query2Context(context.Background(), stunAddr, socket, localAddr, opts...)
+}
+
+// Same as Query, but gives up with an error wrapping ctx.Err() as soon as ctx
+// is done.
+func QueryContext(ctx context.Context, stun string, local string, opts ...ClientOption) (*Result, error) {
+	client, err := NewClient(stun, local, opts...)
 	if err != nil {
 		return nil, err
 	}
-	socket, err := net.ListenUDP("udp", localAddr)
+	defer client.Close()
+	return client.Query(ctx)
+}
+
+func Query1Context(ctx context.Context, stun string, socket *net.UDPConn, local string, opts ...ClientOption) (*Result, error) {
+	client, err := NewClient(stun, local, opts...)
 	if err != nil {
 		return nil, err
 	}
-	defer socket.Close()
-	return Query2(stunAddr, socket, localAddr)
+	client.socket = socket
+	defer client.Close()
+	return client.Query(ctx)
 }
 
-func Query1(stun string, socket *net.UDPConn, local string) (*Result, error) {
-	stunAddr, localAddr, err := getAddr(stun, local)
+func Query2Context(ctx context.Context, stunAddr *net.UDPAddr, socket *net.UDPConn, localAddr *net.UDPAddr, opts ...ClientOption) (*Result, error) {
+	client := NewClient2(stunAddr, localAddr, opts...)
+	client.socket = socket
+	defer client.Close()
+	return client.Query(ctx)
+}
+
+// Classifies the NAT as described in RFC 3489, and tests hairpinning. When a
+// test fails, the error is a *TestError and the result, of type Unknown,
+// still carries what the tests before it learned, such as the public IP.
+func (client *Client) Query(ctx context.Context) (*Result, error) {
+	agent, release, err := client.listen()
 	if err != nil {
 		return nil, err
 	}
-	return Query2(stunAddr, socket, localAddr)
-}
-
-func Query2(stunAddr *net.UDPAddr, socket *net.UDPConn, localAddr *net.UDPAddr) (*Result, error) {
+	defer release()
+	stunAddr := client.serverAddr
 
 	/*
 	    In test I, the client sends a STUN Binding Request to a server, without any flags set in the
@@ -97,112 +322,257 @@ func Query2(stunAddr *net.UDPAddr, socket *net.UDPConn, localAddr *net.UDPAddr)
 	                                  |       Port
 	                                  +------>Restricted
 	*/
-	test1 := NewStunMessage1(BindingRequest)
-
-	if test1Response, err := doTransaction(test1, socket, stunAddr, 100); err == nil {
-
-		// UDP blocked.
-		if test1Response == nil {
-			return NewStunResult(UdpBlocked, nil), nil
-		} else {
-			// Test II
-			test2 := NewStunMessage2(BindingRequest, NewStunChangeRequest(true, true))
-
-			// No NAT.
-			if localAddr.IP.Equal(test1Response.GetMappedAddress().IP) {
-				// IP相同
-				if test2Response, err := doTransaction(test2, socket, stunAddr, TransactionTimeout); err == nil {
-					// Open Internet.
-					if test2Response != nil {
-						return NewStunResult(OpenInternet, test1Response.GetMappedAddress().IP), nil
-					} else // Symmetric UDP firewall.
-					{
-						return NewStunResult(SymmetricUdpFirewall, test1Response.GetMappedAddress().IP), nil
-					}
-				}
-			} else // NAT
-			{
-
-				if test2Response, err := doTransaction(test2, socket, stunAddr, TransactionTimeout); err == nil {
-
-					// Full cone NAT.
-					if test2Response != nil {
-						return NewStunResult(FullCone, test1Response.GetMappedAddress().IP), nil
-					} else {
-						/*
-						   If no response is received, it performs test I again, but this time, does so to
-						   the address and port from the CHANGED-ADDRESS attribute from the response to test I.
-						*/
-
-						// Test I(II)
-						test12 := NewStunMessage1(BindingRequest)
-						if test12Response, err := doTransaction(test12, socket, test1Response.changedAddress, TransactionTimeout); err == nil {
-							if test12Response == nil {
-								return nil, errors.New("STUN Test I(II) didn't get response !")
-							} else {
-								// Symmetric NAT
-								if !test12Response.GetMappedAddress().IP.Equal(test1Response.GetMappedAddress().IP) && test12Response.GetMappedAddress().Port == test1Response.GetMappedAddress().Port {
-									return NewStunResult(Symmetric, test1Response.GetMappedAddress().IP), nil
-								} else {
-									// Test III
-									test3 := NewStunMessage2(BindingRequest, NewStunChangeRequest(false, true))
-
-									if test3Response, err := doTransaction(test3, socket, test1Response.mappedAddress, TransactionTimeout); err == nil {
-										// Restricted
-										if test3Response != nil {
-											return NewStunResult(RestrictedCone, test1Response.GetMappedAddress().IP), nil
-										}
-										// Port restricted else
-										{
-											return NewStunResult(PortRestrictedCone, test1Response.GetMappedAddress().IP), nil
-										}
-									}
-
-								}
-							}
-						}
-
-					}
-				}
-			}
+	// On failure, the result carries what the tests before it learned.
+	var publicIp net.IP
+	hairpinning := HairpinningUnknown
+	fail := func(test Test, err error) (*Result, error) {
+		return NewStunResult1(Unknown, publicIp, hairpinning), &TestError{Test: test, Err: err}
+	}
+
+	test1, err := client.newMessage(BindingRequest, nil)
+	if err != nil {
+		return fail(TestI, err)
+	}
+
+	test1Response, err := client.doTransaction(ctx, TestI, test1, agent, stunAddr)
+	if err != nil {
+		return fail(TestI, err)
+	}
+
+	// UDP blocked.
+	if test1Response == nil {
+		return NewStunResult(UdpBlocked, nil), nil
+	}
+	publicAddr := test1Response.getPublicAddress()
+	if publicAddr == nil {
+		return fail(TestI, ErrNoMappedAddress)
+	}
+	publicIp = publicAddr.IP
+
+	// Test II
+	test2, err := client.newMessage(BindingRequest, NewStunChangeRequest(true, true))
+	if err != nil {
+		return fail(TestII, err)
+	}
+	test2Response, err := client.doTransaction(ctx, TestII, test2, agent, stunAddr)
+	if err != nil {
+		return fail(TestII, err)
+	}
+
+	// No NAT: the server saw the address and port our socket is bound to.
+	if isLocalAddr(publicAddr, agent.socket) {
+		// IP相同
+		// Open Internet.
+		if test2Response != nil {
+			return NewStunResult(OpenInternet, publicAddr.IP), nil
 		}
+		// Symmetric UDP firewall.
+		return NewStunResult(SymmetricUdpFirewall, publicAddr.IP), nil
+	}
+
+	// NAT
+	// Hairpinning: send Test I from a second socket to the mapping of the first.
+	// It is a side measurement, so its failure does not stop the other tests.
+	hairpinning, err = client.testHairpinning(ctx, agent, publicAddr)
+	if err != nil {
+		client.log("%v: %v", TestHairpinning, err)
+		hairpinning = HairpinningUnknown
+	}
+
+	// Full cone NAT.
+	if test2Response != nil {
+		return NewStunResult1(FullCone, publicAddr.IP, hairpinning), nil
+	}
+
+	/*
+	   If no response is received, it performs test I again, but this time, does so to
+	   the address and port from the CHANGED-ADDRESS attribute from the response to test I.
+	   RFC 5780 servers send OTHER-ADDRESS instead.
+	*/
+
+	// Test I(II)
+	alternateAddr := test1Response.getAlternateAddress()
+	if alternateAddr == nil {
+		return fail(TestIAlternate, ErrNoAlternateAddress)
+	}
+	test12, err := client.newMessage(BindingRequest, nil)
+	if err != nil {
+		return fail(TestIAlternate, err)
+	}
+	test12Response, err := client.doTransaction(ctx, TestIAlternate, test12, agent, alternateAddr)
+	if err != nil {
+		return fail(TestIAlternate, err)
+	}
+	if test12Response == nil {
+		return fail(TestIAlternate, ErrNoResponse)
+	}
+
+	// Symmetric NAT
+	test12PublicAddr := test12Response.getPublicAddress()
+	if test12PublicAddr == nil {
+		return fail(TestIAlternate, ErrNoMappedAddress)
+	}
+	if !sameAddr(test12PublicAddr, publicAddr) {
+		return NewStunResult1(Symmetric, publicAddr.IP, hairpinning), nil
+	}
+
+	// Test III goes to the server like Test II: sent to our own mapping, it
+	// would only ever come back as our request.
+	test3, err := client.newMessage(BindingRequest, NewStunChangeRequest(false, true))
+	if err != nil {
+		return fail(TestIII, err)
+	}
+	test3Response, err := client.doTransaction(ctx, TestIII, test3, agent, stunAddr)
+	if err != nil {
+		return fail(TestIII, err)
+	}
+
+	// Restricted
+	if test3Response != nil {
+		return NewStunResult1(RestrictedCone, publicAddr.IP, hairpinning), nil
+	}
+	// Port restricted
+	return NewStunResult1(PortRestrictedCone, publicAddr.IP, hairpinning), nil
+}
+
+// Sends request to the server and returns its response, or nil if there was
+// none. Safe for concurrent use: with WithSocket or WithAgent, all the
+// transactions share one socket, otherwise each opens its own.
+func (client *Client) Do(ctx context.Context, request *Message) (*Message, error) {
+	agent, release, err := client.listen()
+	if err != nil {
+		return nil, err
 	}
-	return NewStunResult(Unknown, nil), nil
+	defer release()
+	return client.doTransaction(ctx, TestRequest, request, agent, client.serverAddr)
+}
+
+// Builds a request and does the transaction.
+func (client *Client) transact(ctx context.Context, test Test, messageType MessageType, changeRequest *Request, agent *Agent, remoteEndPoint net.Addr) (*Message, error) {
+	request, err := client.newMessage(messageType, changeRequest)
+	if err != nil {
+		return nil, err
+	}
+	return client.doTransaction(ctx, test, request, agent, remoteEndPoint)
 }
 
 // Does STUN transaction. Returns transaction response or null if transaction failed.
-// Returns transaction response or null if transaction failed.
-func doTransaction(request *Message, socket *net.UDPConn, remoteEndPoint net.Addr, timeout int) (*Message, error) {
-	requestBytes := request.ToByteData()
-	if request.GetChangeRequest() != nil {
-	}
-
-	revResponse := false // 是否接收到数据的标志位
-	receiveCount := 0
-	receiveBuffer := make([]byte, 512)
-	response := NewStunMessage()
-	for receiveCount < UdpSendCount {
-		_ = socket.SetWriteDeadline(time.Now().Add(time.Duration(timeout) * time.Millisecond))
-		if _, err := socket.WriteTo(requestBytes, remoteEndPoint); err == nil {
-			_ = socket.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Millisecond))
-			if _, err := socket.Read(receiveBuffer); err == nil {
-				// parse message
-				if err := response.Parse(receiveBuffer); err == nil {
-					// Check that transaction ID matches or not response what we want.
-					if bytes.Equal(request.transactionId, response.transactionId) {
-						revResponse = true
-					} else {
-						return nil, errors.New("TransactionId not match!")
-					}
-				}
+func (client *Client) doTransaction(ctx context.Context, test Test, request *Message, agent *Agent, remoteEndPoint net.Addr) (*Message, error) {
+	return client.doTransactionVia(ctx, test, request, agent.socket, agent, remoteEndPoint)
+}
+
+// Same as doTransaction, but sends the request from sendSocket, for requests
+// with RESPONSE-PORT.
+func (client *Client) doTransactionVia(ctx context.Context, test Test, request *Message, sendSocket *net.UDPConn, agent *Agent, remoteEndPoint net.Addr) (*Message, error) {
+	response, rtt, err := client.retransmit(ctx, test, request, sendSocket, agent, remoteEndPoint, func(received *Message, from net.Addr) bool {
+		// Requests and indications with the same ID are not ours.
+		if class := received.GetType().Class(); class != ClassSuccessResponse && class != ClassErrorResponse {
+			client.log("%v: discarding %v from %v", test, received, from)
+			return false
+		}
+		// Discard responses that were tampered with. Error responses
+		// such as 401 may come without MESSAGE-INTEGRITY.
+		if client.integrityKey != nil {
+			err := received.Verify(client.integrityKey)
+			if err != nil && !(err == ErrNoMessageIntegrity && received.GetType().Class() == ClassErrorResponse) {
+				client.log("%v: discarding response from %v: %v", test, from, err)
+				return false
 			}
 		}
-		receiveCount += 1
+		return true
+	})
+	if err != nil || response == nil {
+		return nil, err
 	}
-	if revResponse {
-		return response, nil
-	} else {
-		return nil, nil
+	if rtt > 0 {
+		client.sampleRtt(remoteEndPoint, rtt)
 	}
+	if response.GetType().Class() == ClassErrorResponse {
+		if errorCode := response.GetErrorCode(); errorCode != nil {
+			if errorCode.Is(CodeUnknownAttribute) {
+				return nil, &UnknownAttributesError{Code: errorCode, Types: response.GetUnknownAttributes()}
+			}
+			return nil, errorCode
+		}
+		return nil, ErrMissingErrorCode
+	}
+	return response, nil
+}
+
+// Sends request from sendSocket on the retransmission schedule of test, until
+// agent receives a message with its transaction ID that accept takes. rtt is
+// the round-trip time if the request was only sent once, 0 otherwise.
+func (client *Client) retransmit(ctx context.Context, test Test, request *Message, sendSocket *net.UDPConn, agent *Agent, remoteEndPoint net.Addr, accept func(received *Message, from net.Addr) bool) (received *Message, rtt time.Duration, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	if client.rto <= 0 || client.rc <= 0 || client.rm <= 0 {
+		return nil, 0, ErrInvalidRetransmission
+	}
+	packets, stop, err := agent.start(request.transactionId)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer stop()
+
+	sendBuffer := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(sendBuffer)
+	requestBytes := request.AppendTo((*sendBuffer)[:0])
+
+	var sentAt time.Time
+	var writeErr error
+	sendCount := 0
+	written := false
+	schedule := client.newSchedule(test, remoteEndPoint, time.Now())
+	for {
+		send, ok := schedule.tick(time.Now())
+		if !ok {
+			break
+		}
+		if send {
+			client.log("%v: sending %v to %v", test, request, remoteEndPoint)
+			if sendCount == 0 {
+				sentAt = time.Now()
+			}
+			sendCount++
+			if _, err := sendSocket.WriteTo(requestBytes, remoteEndPoint); err != nil {
+				writeErr = err
+			} else {
+				written = true
+			}
+		}
 
+		timer := time.NewTimer(time.Until(schedule.wait()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, 0, ctx.Err()
+		case <-agent.done:
+			timer.Stop()
+			return nil, 0, agent.err
+		case <-timer.C:
+		case packet := <-packets:
+			timer.Stop()
+			// The agent copied the packet, so the message may refer to it.
+			// Rejected messages go back to the pool, accepted ones to the
+			// caller.
+			message := AcquireMessage()
+			if err := message.Parse(packet.data); err != nil || !accept(message, packet.from) {
+				ReleaseMessage(message)
+				// Wait for another packet with the same ID.
+				continue
+			}
+			client.log("%v: received %v from %v", test, message, packet.from)
+			if sendCount == 1 {
+				rtt = time.Since(sentAt)
+			}
+			return message, rtt, nil
+		}
+	}
+	// A request that never left the socket is not a missing response.
+	if !written && writeErr != nil {
+		return nil, 0, writeErr
+	}
+	client.log("%v: no response from %v", test, remoteEndPoint)
+	return nil, 0, nil
 }
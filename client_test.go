@@ -0,0 +1,306 @@
+package stun
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// A Client of server bound to 127.0.0.1, with timeouts short enough for the
+// tests that expect no response.
+func newFakeClient(server *fakeServer, opts ...ClientOption) *Client {
+	opts = append([]ClientOption{
+		WithRetransmission(20*time.Millisecond, 7, 16),
+		WithTimeout(300 * time.Millisecond),
+		WithTestTimeout(TestHairpinning, 100*time.Millisecond),
+	}, opts...)
+	return NewClient2(server.primary(), &net.UDPAddr{IP: fakePrimaryIp}, opts...)
+}
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		nat  fakeNat
+		want NatType
+	}{
+		{fakeNat{NoNatMapping, EndpointIndependentFiltering}, OpenInternet},
+		{fakeNat{NoNatMapping, AddressAndPortDependentFiltering}, SymmetricUdpFirewall},
+		{fakeNat{EndpointIndependentMapping, EndpointIndependentFiltering}, FullCone},
+		{fakeNat{EndpointIndependentMapping, AddressDependentFiltering}, RestrictedCone},
+		{fakeNat{EndpointIndependentMapping, AddressAndPortDependentFiltering}, PortRestrictedCone},
+		{fakeNat{AddressDependentMapping, AddressAndPortDependentFiltering}, Symmetric},
+		{fakeNat{AddressAndPortDependentMapping, AddressAndPortDependentFiltering}, Symmetric},
+	}
+	for _, test := range tests {
+		t.Run(test.nat.mapping.String()+"/"+test.nat.filtering.String(), func(t *testing.T) {
+			server := startFakeServer(t, &fakeServer{nat: test.nat})
+			result, err := newFakeClient(server).Query(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.GetNatType() != test.want {
+				t.Errorf("got %v, want %v", result.GetNatType(), test.want)
+			}
+			wantIp := fakeNatIp
+			if test.nat.mapping == NoNatMapping {
+				wantIp = fakePrimaryIp
+			}
+			if !result.GetIpAddr().Equal(wantIp) {
+				t.Errorf("got public IP %v, want %v", result.GetIpAddr(), wantIp)
+			}
+			// Without a NAT, there is no mapping to test.
+			if test.nat.mapping == NoNatMapping && result.GetHairpinning() != HairpinningUnknown {
+				t.Errorf("got %v without NAT, want %v", result.GetHairpinning(), HairpinningUnknown)
+			}
+		})
+	}
+}
+
+func TestQueryUnspecifiedLocalAddress(t *testing.T) {
+	// The server sees the address of an interface, not the one bound.
+	for _, test := range []struct {
+		filtering FilteringBehavior
+		want      NatType
+	}{
+		{EndpointIndependentFiltering, OpenInternet},
+		{AddressAndPortDependentFiltering, SymmetricUdpFirewall},
+	} {
+		server := startFakeServer(t, &fakeServer{nat: fakeNat{NoNatMapping, test.filtering}})
+		client := newFakeClient(server)
+		client.localAddr = &net.UDPAddr{IP: net.IPv4zero}
+		result, err := client.Query(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.GetNatType() != test.want {
+			t.Errorf("%v: got %v, want %v", test.filtering, result.GetNatType(), test.want)
+		}
+	}
+}
+
+func TestQueryHairpinning(t *testing.T) {
+	// The client listens on all addresses, and the NAT has the public IP
+	// 127.0.0.3: a probe sent to a mapping on the port of the client reaches
+	// it, as if the NAT looped it back. With address-dependent mapping, the
+	// mapped port is another one, where the probe is dropped.
+	tests := []struct {
+		mapping MappingBehavior
+		want    HairpinningBehavior
+	}{
+		{EndpointIndependentMapping, Hairpinning},
+		{AddressDependentMapping, NoHairpinning},
+	}
+	for _, test := range tests {
+		t.Run(test.want.String(), func(t *testing.T) {
+			server := startFakeServer(t, &fakeServer{
+				nat:   fakeNat{test.mapping, EndpointIndependentFiltering},
+				natIp: net.IPv4(127, 0, 0, 3).To4(),
+			})
+			client := newFakeClient(server)
+			client.localAddr = &net.UDPAddr{IP: net.IPv4zero}
+			result, err := client.Query(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.GetNatType() != FullCone {
+				t.Errorf("got %v, want %v", result.GetNatType(), FullCone)
+			}
+			if result.GetHairpinning() != test.want {
+				t.Errorf("got %v, want %v", result.GetHairpinning(), test.want)
+			}
+		})
+	}
+}
+
+func TestQueryFailure(t *testing.T) {
+	changeRequested := func(changeIp bool) func(request *Message, on *net.UDPAddr) *Code {
+		return func(request *Message, on *net.UDPAddr) *Code {
+			if changeRequest := request.GetChangeRequest(); changeRequest != nil && changeRequest.IsChangeIp() == changeIp {
+				return CodeServerError
+			}
+			return nil
+		}
+	}
+	tests := []struct {
+		name     string
+		server   *fakeServer
+		canceled bool
+		test     Test
+		err      error
+		publicIp net.IP
+	}{
+		{
+			name:   "no mapped address",
+			server: &fakeServer{nat: fakeNat{EndpointIndependentMapping, EndpointIndependentFiltering}, noMappedAddress: true},
+			test:   TestI,
+			err:    ErrNoMappedAddress,
+		},
+		{
+			name:     "canceled",
+			server:   &fakeServer{nat: fakeNat{EndpointIndependentMapping, EndpointIndependentFiltering}},
+			canceled: true,
+			test:     TestI,
+			err:      context.Canceled,
+		},
+		{
+			name:     "test II error response",
+			server:   &fakeServer{nat: fakeNat{EndpointIndependentMapping, EndpointIndependentFiltering}, errorCode: changeRequested(true)},
+			test:     TestII,
+			err:      CodeServerError,
+			publicIp: fakeNatIp,
+		},
+		{
+			name:     "no alternate address",
+			server:   &fakeServer{nat: fakeNat{EndpointIndependentMapping, AddressAndPortDependentFiltering}, noOtherAddress: true},
+			test:     TestIAlternate,
+			err:      ErrNoAlternateAddress,
+			publicIp: fakeNatIp,
+		},
+		{
+			name: "no response from the alternate address",
+			server: &fakeServer{nat: fakeNat{EndpointIndependentMapping, AddressAndPortDependentFiltering}, drop: func(request *Message, on *net.UDPAddr) bool {
+				return on.IP.Equal(fakeAlternateIp)
+			}},
+			test:     TestIAlternate,
+			err:      ErrNoResponse,
+			publicIp: fakeNatIp,
+		},
+		{
+			name:     "test III error response",
+			server:   &fakeServer{nat: fakeNat{EndpointIndependentMapping, AddressDependentFiltering}, errorCode: changeRequested(false)},
+			test:     TestIII,
+			err:      CodeServerError,
+			publicIp: fakeNatIp,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startFakeServer(t, test.server)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.canceled {
+				cancel()
+			}
+			result, err := newFakeClient(server).Query(ctx)
+			var testError *TestError
+			if !errors.As(err, &testError) {
+				t.Fatalf("got error %v, want a *TestError", err)
+			}
+			if testError.Test != test.test || !errors.Is(err, test.err) {
+				t.Errorf("got error %v, want %v in %v", err, test.err, test.test)
+			}
+			if result == nil {
+				t.Fatal("got no result")
+			}
+			if result.GetNatType() != Unknown {
+				t.Errorf("got %v, want %v", result.GetNatType(), Unknown)
+			}
+			if !result.GetIpAddr().Equal(test.publicIp) {
+				t.Errorf("got public IP %v, want %v", result.GetIpAddr(), test.publicIp)
+			}
+		})
+	}
+}
+
+func TestQueryIntegrity(t *testing.T) {
+	key := ShortTermKey("password")
+	// The server requires requests signed with key.
+	unauthorized := func(request *Message, on *net.UDPAddr) *Code {
+		if request.Verify(key) != nil {
+			return CodeUnauthorized
+		}
+		return nil
+	}
+	tests := []struct {
+		name      string
+		signedBy  []byte
+		errorCode func(request *Message, on *net.UDPAddr) *Code
+		want      NatType
+		err       error
+	}{
+		{name: "signed", signedBy: key, errorCode: unauthorized, want: FullCone},
+		{name: "signed with another key", signedBy: ShortTermKey("other"), want: UdpBlocked},
+		{name: "not signed", want: UdpBlocked},
+		{name: "error response signed with another key", signedBy: ShortTermKey("other"), errorCode: func(*Message, *net.UDPAddr) *Code { return CodeUnauthorized }, want: UdpBlocked},
+		{name: "error response not signed", errorCode: func(*Message, *net.UDPAddr) *Code { return CodeUnauthorized }, want: Unknown, err: CodeUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startFakeServer(t, &fakeServer{
+				nat:          fakeNat{EndpointIndependentMapping, EndpointIndependentFiltering},
+				errorCode:    test.errorCode,
+				integrityKey: test.signedBy,
+			})
+			result, err := newFakeClient(server, WithIntegrityKey(key)).Query(context.Background())
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if result == nil || result.GetNatType() != test.want {
+				t.Errorf("got %v, want %v", result, test.want)
+			}
+		})
+	}
+}
+
+func TestQueryFingerprint(t *testing.T) {
+	key := ShortTermKey("password")
+	// The server rejects requests that do not end with FINGERPRINT, or whose
+	// MESSAGE-INTEGRITY does not cover everything before it.
+	badRequest := func(request *Message, on *net.UDPAddr) *Code {
+		if attributes := request.GetAttributes(); len(attributes) == 0 || attributes[len(attributes)-1].Type != Fingerprint {
+			return CodeBadRequest
+		}
+		if err := request.Verify(key); err != nil && err != ErrNoMessageIntegrity {
+			return CodeBadRequest
+		}
+		return nil
+	}
+	tests := []struct {
+		name string
+		opts []ClientOption
+		err  error
+	}{
+		{name: "always", opts: []ClientOption{WithAlwaysFingerprint()}},
+		{name: "always with integrity", opts: []ClientOption{WithAlwaysFingerprint(), WithIntegrityKey(key)}},
+		{name: "not asked", err: CodeBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startFakeServer(t, &fakeServer{
+				nat:          fakeNat{EndpointIndependentMapping, EndpointIndependentFiltering},
+				errorCode:    badRequest,
+				integrityKey: key,
+			})
+			_, err := newFakeClient(server, test.opts...).Query(context.Background())
+			if !errors.Is(err, test.err) {
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestWithRandReader(t *testing.T) {
+	random := []byte("0123456789ab")
+	build := func(random []byte) *Message {
+		client := NewClient2(&net.UDPAddr{IP: fakePrimaryIp, Port: 3478}, &net.UDPAddr{IP: fakePrimaryIp},
+			WithRandReader(bytes.NewReader(random)), WithIntegrityKey(ShortTermKey("password")), WithAlwaysFingerprint())
+		defer client.Close()
+		request, err := client.newMessage(BindingRequest, NewStunChangeRequest(true, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return request
+	}
+	first, second := build(random), build(random)
+	if got := first.GetTransactionId(); !bytes.Equal(got, random) {
+		t.Errorf("got transaction ID %x, want %x", got, random)
+	}
+	if !bytes.Equal(first.ToByteData(), second.ToByteData()) {
+		t.Errorf("got different requests from the same reader:\n%x\n%x", first.ToByteData(), second.ToByteData())
+	}
+	if other := build([]byte("ba9876543210")); bytes.Equal(other.GetTransactionId(), first.GetTransactionId()) {
+		t.Errorf("got transaction ID %x from another reader", other.GetTransactionId())
+	}
+}
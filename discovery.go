@@ -0,0 +1,176 @@
+package stun
+
+import (
+	"context"
+	"net"
+)
+
+func sameAddr(a *net.UDPAddr, b *net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
+// Tells whether mappedAddr is the address socket is bound to, i.e. there is no
+// NAT. A socket bound to an unspecified IP address receives on the addresses
+// of all the interfaces.
+func isLocalAddr(mappedAddr *net.UDPAddr, socket *net.UDPConn) bool {
+	localAddr, ok := socket.LocalAddr().(*net.UDPAddr)
+	if !ok || localAddr.Port != mappedAddr.Port {
+		return false
+	}
+	if !localAddr.IP.IsUnspecified() {
+		return localAddr.IP.Equal(mappedAddr.IP)
+	}
+	interfaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, interfaceAddr := range interfaceAddrs {
+		if ipNet, ok := interfaceAddr.(*net.IPNet); ok && ipNet.IP.Equal(mappedAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Discovers the mapping and filtering behavior of the NAT as described in
+// RFC 5780 4.3 and 4.4. The server must support RFC 5780, i.e. have a second
+// IP address and port and send OTHER-ADDRESS (or CHANGED-ADDRESS).
+func DiscoverBehavior(stun string, local string, opts ...ClientOption) (*BehaviorResult, error) {
+	return DiscoverBehaviorContext(context.Background(), stun, local, opts...)
+}
+
+func DiscoverBehavior2(stunAddr *net.UDPAddr, socket *net.UDPConn, localAddr *net.UDPAddr, opts ...ClientOption) (*BehaviorResult, error) {
+	return DiscoverBehavior2Context(context.Background(), stunAddr, socket, localAddr, opts...)
+}
+
+// Same as DiscoverBehavior, but gives up with an error wrapping ctx.Err() as
+// soon as ctx is done.
+func DiscoverBehaviorContext(ctx context.Context, stun string, local string, opts ...ClientOption) (*BehaviorResult, error) {
+	client, err := NewClient(stun, local, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.DiscoverBehavior(ctx)
+}
+
+func DiscoverBehavior2Context(ctx context.Context, stunAddr *net.UDPAddr, socket *net.UDPConn, localAddr *net.UDPAddr, opts ...ClientOption) (*BehaviorResult, error) {
+	client := NewClient2(stunAddr, localAddr, opts...)
+	client.socket = socket
+	defer client.Close()
+	return client.DiscoverBehavior(ctx)
+}
+
+// See the package-level DiscoverBehavior. When a test fails, the error is a
+// *TestError and the result still carries what the tests before it learned,
+// such as the mapped address.
+func (client *Client) DiscoverBehavior(ctx context.Context) (*BehaviorResult, error) {
+	agent, release, err := client.listen()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	stunAddr := client.serverAddr
+
+	/*
+	   The filtering tests only talk to the primary address of the server, and
+	   run before the mapping tests: once we have sent packets to the alternate
+	   address, the NAT lets its responses through whatever its filtering.
+
+	   Test I:   Binding Request to the primary address.
+	   Filtering test II:  "change IP" and "change port" set. A response means
+	             endpoint-independent filtering.
+	   Filtering test III: only "change port" set. A response means address-
+	             dependent filtering, none means address and port-dependent.
+	   Mapping test II:  Binding Request to the alternate IP address and the
+	             primary port. The same mapped address as in test I means
+	             endpoint-independent mapping.
+	   Mapping test III: Binding Request to the alternate IP address and port.
+	             The same mapped address as in test II means address-dependent
+	             mapping, otherwise address and port-dependent.
+	*/
+
+	// On failure, the result carries what the tests before it learned.
+	var mappedAddr *net.UDPAddr
+	mapping, filtering := MappingUnknown, FilteringUnknown
+	fail := func(test Test, err error) (*BehaviorResult, error) {
+		return NewStunBehaviorResult(mappedAddr, mapping, filtering), &TestError{Test: test, Err: err}
+	}
+
+	// Test I
+	test1Response, err := client.transact(ctx, TestI, BindingRequest, nil, agent, stunAddr)
+	if err != nil {
+		return fail(TestI, err)
+	}
+	if test1Response == nil {
+		return fail(TestI, ErrNoResponse)
+	}
+	mappedAddr = test1Response.getPublicAddress()
+	if mappedAddr == nil {
+		return fail(TestI, ErrNoMappedAddress)
+	}
+	otherAddr := test1Response.getAlternateAddress()
+	if otherAddr == nil {
+		return fail(TestI, ErrNoAlternateAddress)
+	}
+
+	// Filtering test II
+	test2Response, err := client.transact(ctx, TestII, BindingRequest, NewStunChangeRequest(true, true), agent, stunAddr)
+	if err != nil {
+		return fail(TestII, err)
+	}
+	if test2Response != nil {
+		filtering = EndpointIndependentFiltering
+	} else {
+		// Filtering test III
+		test3Response, err := client.transact(ctx, TestIII, BindingRequest, NewStunChangeRequest(false, true), agent, stunAddr)
+		if err != nil {
+			return fail(TestIII, err)
+		}
+		if test3Response != nil {
+			filtering = AddressDependentFiltering
+		} else {
+			filtering = AddressAndPortDependentFiltering
+		}
+	}
+
+	// No NAT: the server saw the address and port our socket is bound to.
+	if isLocalAddr(mappedAddr, agent.socket) {
+		return NewStunBehaviorResult(mappedAddr, NoNatMapping, filtering), nil
+	}
+
+	// Mapping test II
+	test2Response, err = client.transact(ctx, TestMappingII, BindingRequest, nil, agent, &net.UDPAddr{IP: otherAddr.IP, Port: stunAddr.Port})
+	if err != nil {
+		return fail(TestMappingII, err)
+	}
+	if test2Response == nil {
+		return fail(TestMappingII, ErrNoResponse)
+	}
+	test2MappedAddr := test2Response.getPublicAddress()
+	if test2MappedAddr == nil {
+		return fail(TestMappingII, ErrNoMappedAddress)
+	}
+	if sameAddr(test2MappedAddr, mappedAddr) {
+		return NewStunBehaviorResult(mappedAddr, EndpointIndependentMapping, filtering), nil
+	}
+
+	// Mapping test III
+	test3Response, err := client.transact(ctx, TestMappingIII, BindingRequest, nil, agent, otherAddr)
+	if err != nil {
+		return fail(TestMappingIII, err)
+	}
+	if test3Response == nil {
+		return fail(TestMappingIII, ErrNoResponse)
+	}
+	test3MappedAddr := test3Response.getPublicAddress()
+	if test3MappedAddr == nil {
+		return fail(TestMappingIII, ErrNoMappedAddress)
+	}
+	if sameAddr(test3MappedAddr, test2MappedAddr) {
+		mapping = AddressDependentMapping
+	} else {
+		mapping = AddressAndPortDependentMapping
+	}
+	return NewStunBehaviorResult(mappedAddr, mapping, filtering), nil
+}
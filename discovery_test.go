@@ -0,0 +1,41 @@
+package stun
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestDiscoverBehavior(t *testing.T) {
+	tests := []struct {
+		nat     fakeNat
+		localIp net.IP
+	}{
+		{fakeNat{NoNatMapping, EndpointIndependentFiltering}, fakePrimaryIp},
+		{fakeNat{NoNatMapping, AddressAndPortDependentFiltering}, fakePrimaryIp},
+		// The mapped address is that of an interface, not the one bound.
+		{fakeNat{NoNatMapping, EndpointIndependentFiltering}, net.IPv4zero},
+		{fakeNat{EndpointIndependentMapping, EndpointIndependentFiltering}, fakePrimaryIp},
+		{fakeNat{EndpointIndependentMapping, AddressDependentFiltering}, fakePrimaryIp},
+		{fakeNat{EndpointIndependentMapping, AddressAndPortDependentFiltering}, fakePrimaryIp},
+		{fakeNat{AddressDependentMapping, AddressAndPortDependentFiltering}, fakePrimaryIp},
+		{fakeNat{AddressAndPortDependentMapping, AddressAndPortDependentFiltering}, fakePrimaryIp},
+	}
+	for _, test := range tests {
+		t.Run(test.nat.mapping.String()+"/"+test.nat.filtering.String()+"/"+test.localIp.String(), func(t *testing.T) {
+			server := startFakeServer(t, &fakeServer{nat: test.nat})
+			client := newFakeClient(server)
+			client.localAddr = &net.UDPAddr{IP: test.localIp}
+			result, err := client.DiscoverBehavior(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.GetMappingBehavior() != test.nat.mapping {
+				t.Errorf("got %v, want %v", result.GetMappingBehavior(), test.nat.mapping)
+			}
+			if result.GetFilteringBehavior() != test.nat.filtering {
+				t.Errorf("got %v, want %v", result.GetFilteringBehavior(), test.nat.filtering)
+			}
+		})
+	}
+}
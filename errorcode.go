@@ -1,29 +1,131 @@
 package stun
 
+import "fmt"
+
+// The ERROR-CODE of an error response. It is also the error the client
+// returns for error responses, so it works with errors.Is and errors.As:
+//
+//	if errors.Is(err, stun.CodeUnauthorized) { ... }
 type Code struct {
 	code       int
 	reasonText string
 }
 
-func (errorCode Code) GetCode() int {
+func (errorCode *Code) GetCode() int {
 	return errorCode.code
 }
 
-func (errorCode Code) GetReasonText() string {
+func (errorCode *Code) GetReasonText() string {
 	return errorCode.reasonText
 }
 
-func (errorCode Code) SetCode(code int) {
+func (errorCode *Code) SetCode(code int) {
 	errorCode.code = code
 }
 
-func (errorCode Code) SetReasonText(reasonText string) {
+func (errorCode *Code) SetReasonText(reasonText string) {
 	errorCode.reasonText = reasonText
 }
 
+func (errorCode *Code) Error() string {
+	return fmt.Sprintf("STUN error %d: %s", errorCode.code, errorCode.reasonText)
+}
+
+// Error codes are equal when their numbers are, whatever the reason phrase.
+func (errorCode *Code) Is(target error) bool {
+	if code, ok := target.(*Code); ok {
+		return code != nil && errorCode.code == code.code
+	}
+	return false
+}
+
+// Returns an error code, with the standard reason phrase if reasonText is empty.
 func NewStunErrorCode(code int, reasonText string) *Code {
+	if reasonText == "" {
+		reasonText = reasonPhrases[code]
+	}
 	return &Code{
 		code:       code,
 		reasonText: reasonText,
 	}
 }
+
+// Reason phrases of the error codes defined by RFC 3489, RFC 5389,
+// RFC 5766 (TURN) and RFC 5245 (ICE).
+var reasonPhrases = map[int]string{
+	300: "Try Alternate",
+	400: "Bad Request",
+	401: "Unauthorized",
+	403: "Forbidden",
+	420: "Unknown Attribute",
+	430: "Stale Credentials",
+	431: "Integrity Check Failure",
+	432: "Missing Username",
+	433: "Use TLS",
+	437: "Allocation Mismatch",
+	438: "Stale Nonce",
+	441: "Wrong Credentials",
+	442: "Unsupported Transport Protocol",
+	486: "Allocation Quota Reached",
+	487: "Role Conflict",
+	500: "Server Error",
+	508: "Insufficient Capacity",
+	600: "Global Failure",
+}
+
+// Standard error codes, to compare errors against with errors.Is. Do not modify them.
+var (
+	CodeTryAlternate                 = NewStunErrorCode(300, "")
+	CodeBadRequest                   = NewStunErrorCode(400, "")
+	CodeUnauthorized                 = NewStunErrorCode(401, "")
+	CodeForbidden                    = NewStunErrorCode(403, "")
+	CodeUnknownAttribute             = NewStunErrorCode(420, "")
+	CodeStaleCredentials             = NewStunErrorCode(430, "")
+	CodeIntegrityCheckFailure        = NewStunErrorCode(431, "")
+	CodeMissingUsername              = NewStunErrorCode(432, "")
+	CodeUseTLS                       = NewStunErrorCode(433, "")
+	CodeAllocationMismatch           = NewStunErrorCode(437, "")
+	CodeStaleNonce                   = NewStunErrorCode(438, "")
+	CodeWrongCredentials             = NewStunErrorCode(441, "")
+	CodeUnsupportedTransportProtocol = NewStunErrorCode(442, "")
+	CodeAllocationQuotaReached       = NewStunErrorCode(486, "")
+	CodeRoleConflict                 = NewStunErrorCode(487, "")
+	CodeServerError                  = NewStunErrorCode(500, "")
+	CodeInsufficientCapacity         = NewStunErrorCode(508, "")
+	CodeGlobalFailure                = NewStunErrorCode(600, "")
+)
+
+func (errorCode *Code) toByteData() []byte {
+	/* RFC 5389 15.6.
+	   0                   1                   2                   3
+	   0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	   |           Reserved, should be 0         |Class|     Number    |
+	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	   |      Reason Phrase (variable)                                ..
+	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	   The Class represents the hundreds digit of the error code.  The
+	   value MUST be between 3 and 6.  The Number represents the error
+	   code modulo 100, and its value MUST be between 0 and 99.
+	*/
+	reasonBytes := []byte(errorCode.reasonText)
+	value := make([]byte, 4+len(reasonBytes))
+	// Class
+	value[2] = byte(errorCode.code/100) & 0x7
+	// Number
+	value[3] = byte(errorCode.code % 100)
+	// ReasonPhrase, padded when the message is encoded.
+	copy(value[4:], reasonBytes)
+	return value
+}
+
+func parseErrorCode(value []byte) *Code {
+	if len(value) < 4 {
+		return nil
+	}
+	code := int(value[2]&0x7)*100 + int(value[3])
+	return &Code{
+		code:       code,
+		reasonText: string(value[4:]),
+	}
+}
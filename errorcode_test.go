@@ -0,0 +1,84 @@
+package stun
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestErrorCodeEncoding(t *testing.T) {
+	value := CodeUnknownAttribute.toByteData()
+	// 420 is class 4, number 20.
+	if value[2] != 4 || value[3] != 20 {
+		t.Errorf("class %d number %d, want class 4 number 20", value[2], value[3])
+	}
+	if got := string(value[4:]); got != "Unknown Attribute" {
+		t.Errorf("reason phrase %q, want %q", got, "Unknown Attribute")
+	}
+
+	for _, code := range []int{300, 420, 438, 599, 699} {
+		sent, err := Build(BindingErrorResponse, WithErrorCode(NewStunErrorCode(code, "reason")))
+		if err != nil {
+			t.Fatalf("%d: %v", code, err)
+		}
+		received := NewStunMessage()
+		if err := received.Parse(sent.ToByteData()); err != nil {
+			t.Fatalf("%d: Parse() = %v", code, err)
+		}
+		errorCode := received.GetErrorCode()
+		if errorCode == nil || errorCode.GetCode() != code || errorCode.GetReasonText() != "reason" {
+			t.Errorf("GetErrorCode() = %v, want %d reason", errorCode, code)
+		}
+	}
+}
+
+func TestParseRejectsInvalidErrorCode(t *testing.T) {
+	// Binding error responses whose ERROR-CODE has class 2, class 7, and
+	// number 100.
+	for _, seed := range []string{
+		`011100082112a4420123456789abcdef01234567 0009000400000214`,
+		`011100082112a4420123456789abcdef01234567 0009000400000714`,
+		`011100082112a4420123456789abcdef01234567 0009000400000464`,
+	} {
+		message := NewStunMessage()
+		var attributeError *AttributeError
+		err := message.Parse(decodeSeed(seed))
+		if !errors.Is(err, ErrInvalidErrorCode) || !errors.As(err, &attributeError) || attributeError.Type != ErrorCode {
+			t.Errorf("Parse(%s) = %v, want %v in ERROR-CODE", seed, err, ErrInvalidErrorCode)
+		}
+	}
+}
+
+func TestClientErrorResponse(t *testing.T) {
+	server := startFakeServer(t, &fakeServer{errorCode: func(request *Message, on *net.UDPAddr) *Code {
+		if request.GetChangeRequest() != nil {
+			return CodeUnknownAttribute
+		}
+		return NewStunErrorCode(401, "who are you")
+	}})
+	client := newFakeClient(server)
+
+	request, err := Build(BindingRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Do(context.Background(), request)
+	if !errors.Is(err, CodeUnauthorized) {
+		t.Errorf("Do() = %v, want %v", err, CodeUnauthorized)
+	}
+	var errorCode *Code
+	if !errors.As(err, &errorCode) || errorCode.GetReasonText() != "who are you" {
+		t.Errorf("Do() = %v, want the reason phrase of the server", err)
+	}
+
+	request, err = Build(BindingRequest, WithChangeRequest(false, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Do(context.Background(), request)
+	var unknownAttributes *UnknownAttributesError
+	if !errors.As(err, &unknownAttributes) || !errors.Is(err, CodeUnknownAttribute) {
+		t.Errorf("Do() = %v, want an *UnknownAttributesError", err)
+	}
+}
@@ -0,0 +1,149 @@
+package stun
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// The packet is shorter than the 20-byte STUN header.
+	ErrMessageTooShort = errors.New("STUN message is shorter than its header")
+
+	// The two most significant bits of the message type are not zero.
+	ErrInvalidMessageType = errors.New("invalid STUN message type")
+
+	// The message length in the header is not a multiple of 4.
+	ErrInvalidMessageLength = errors.New("invalid STUN message length")
+
+	// The packet ends before the message length in the header says it should.
+	ErrMessageTruncated = errors.New("STUN message is truncated")
+
+	// An attribute, or its padding, runs past the end of the message.
+	ErrAttributeTruncated = errors.New("STUN attribute is truncated")
+
+	// The value of an attribute has a length its type does not allow.
+	ErrInvalidAttributeLength = errors.New("invalid STUN attribute length")
+
+	// An address attribute has a family other than IPv4 or IPv6.
+	ErrInvalidAddressFamily = errors.New("invalid STUN address family")
+
+	// An address given to Build has no valid IPv4 or IPv6 address.
+	ErrInvalidAddress = errors.New("invalid STUN address")
+
+	// A string attribute is longer than its type allows.
+	ErrAttributeTooLong = errors.New("STUN attribute is too long")
+
+	// An error code is outside of 300-699, or its number, the code modulo
+	// 100, is encoded as 100 or more.
+	ErrInvalidErrorCode = errors.New("invalid STUN error code")
+
+	// A transaction ID is not 96 bits long.
+	ErrInvalidTransactionId = errors.New("STUN transaction ID must be 12 bytes")
+
+	// Build was given the same attribute twice.
+	ErrDuplicateAttribute = errors.New("duplicate STUN attribute")
+
+	// Build was given an attribute that does not belong in the message class.
+	ErrAttributeNotAllowed = errors.New("STUN attribute not allowed in message")
+
+	// Build was asked for an error response without an error code.
+	ErrMissingErrorCode = errors.New("STUN error response without ERROR-CODE")
+
+	// Build was asked for long-term MESSAGE-INTEGRITY without USERNAME, REALM and NONCE.
+	ErrMissingCredentials = errors.New("STUN long-term credentials need USERNAME, REALM and NONCE")
+
+	// The server did not answer.
+	ErrNoResponse = errors.New("no STUN response")
+
+	// The response carries neither XOR-MAPPED-ADDRESS nor MAPPED-ADDRESS.
+	ErrNoMappedAddress = errors.New("STUN response has no mapped address")
+
+	// The response carries neither OTHER-ADDRESS nor CHANGED-ADDRESS, so the
+	// server cannot take part in tests that need its alternate address.
+	ErrNoAlternateAddress = errors.New("STUN response has no OTHER-ADDRESS or CHANGED-ADDRESS")
+
+	// A response redirected with RESPONSE-PORT did not arrive even without
+	// idling, so the server or the NAT cannot take part in lifetime probes.
+	ErrResponsePortFailed = errors.New("STUN response to RESPONSE-PORT did not arrive")
+
+	// The bounds or precision of MeasureLifetime are not positive, or the
+	// upper bound is below the lower one.
+	ErrInvalidLifetimeBounds = errors.New("invalid STUN lifetime bounds")
+
+	// The RTO, Rc or Rm of WithRetransmission is not positive.
+	ErrInvalidRetransmission = errors.New("invalid STUN retransmission schedule")
+
+	// The Agent was closed, or its socket failed.
+	ErrAgentClosed = errors.New("STUN agent is closed")
+
+	// A transaction with the same ID is already running on the Agent.
+	ErrDuplicateTransaction = errors.New("STUN transaction ID is already in use")
+
+	// A string attribute is not valid UTF-8.
+	ErrInvalidUTF8 = errors.New("STUN attribute is not valid UTF-8")
+
+	// The message carries no MESSAGE-INTEGRITY attribute to verify.
+	ErrNoMessageIntegrity = errors.New("STUN message has no MESSAGE-INTEGRITY")
+
+	// MESSAGE-INTEGRITY does not match the message and key.
+	ErrIntegrityMismatch = errors.New("STUN MESSAGE-INTEGRITY mismatch")
+
+	// The message carries no FINGERPRINT attribute but one is required.
+	ErrNoFingerprint = errors.New("STUN message has no FINGERPRINT")
+
+	// FINGERPRINT does not match the message.
+	ErrFingerprintMismatch = errors.New("STUN FINGERPRINT mismatch")
+
+	// FINGERPRINT is followed by other attributes.
+	ErrFingerprintNotLast = errors.New("STUN FINGERPRINT is not the last attribute")
+)
+
+// Reports which attribute of a message could not be parsed and why.
+type AttributeError struct {
+	Type   AttributeType
+	Offset int
+	Err    error
+}
+
+func (e *AttributeError) Error() string {
+	return fmt.Sprintf("STUN attribute 0x%04X at offset %d: %v", uint(e.Type), e.Offset, e.Err)
+}
+
+func (e *AttributeError) Unwrap() error {
+	return e.Err
+}
+
+// Reports which address could not be resolved: the STUN server, or the local
+// address if Local is set.
+type ResolveError struct {
+	Address string
+	Local   bool
+	Err     error
+}
+
+func (e *ResolveError) Error() string {
+	if e.Local {
+		return fmt.Sprintf("invalid local address %q: %v", e.Address, e.Err)
+	}
+	return fmt.Sprintf("invalid STUN server address %q: %v", e.Address, e.Err)
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// Reports which test of Query or DiscoverBehavior failed and why: one of the
+// errors above, a *Code the server answered with, a socket error or
+// ctx.Err().
+type TestError struct {
+	Test Test
+	Err  error
+}
+
+func (e *TestError) Error() string {
+	return fmt.Sprintf("STUN %v failed: %v", e.Test, e.Err)
+}
+
+func (e *TestError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,231 @@
+package stun
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The NAT between the client and a fakeServer, simulated by the server: it
+// reports mapped addresses following mapping, and drops responses following
+// filtering. NoNatMapping reports the real address of the client.
+type fakeNat struct {
+	mapping   MappingBehavior
+	filtering FilteringBehavior
+}
+
+// A STUN server listening on 127.0.0.1 and 127.0.0.2, each on two ports, so
+// that it can honour CHANGE-REQUEST and RESPONSE-PORT.
+type fakeServer struct {
+	port  int
+	conns map[string]*net.UDPConn
+	nat   fakeNat
+	// The public IP address of the NAT, fakeNatIp if nil.
+	natIp net.IP
+	// NAT mappings idle for longer expire, and responses sent to them with
+	// RESPONSE-PORT are dropped; 0 if they never expire.
+	lifetime time.Duration
+
+	// Optional: drop a request received on, or answer it with an error.
+	drop      func(request *Message, on *net.UDPAddr) bool
+	errorCode func(request *Message, on *net.UDPAddr) *Code
+	// Leave out OTHER-ADDRESS, or the mapped address, from responses.
+	noOtherAddress  bool
+	noMappedAddress bool
+	// Sign responses with MESSAGE-INTEGRITY using integrityKey, if not nil.
+	integrityKey []byte
+
+	mutex sync.Mutex
+	// The server addresses each client address sent requests to.
+	contacted map[string]map[string]bool
+	// When each client address last sent a request.
+	lastSeen map[string]time.Time
+}
+
+var (
+	fakePrimaryIp   = net.IPv4(127, 0, 0, 1).To4()
+	fakeAlternateIp = net.IPv4(127, 0, 0, 2).To4()
+	// The public IP address of the simulated NAT.
+	fakeNatIp = net.IPv4(192, 0, 2, 1).To4()
+)
+
+// Starts server on free ports; configure it before. It stops with the test.
+func startFakeServer(t *testing.T, server *fakeServer) *fakeServer {
+	t.Helper()
+	server.contacted = map[string]map[string]bool{}
+	server.lastSeen = map[string]time.Time{}
+	for attempt := 0; attempt < 20 && server.conns == nil; attempt++ {
+		server.conns = listenFakeServer(t)
+	}
+	if server.conns == nil {
+		t.Skip("cannot listen on 127.0.0.1 and 127.0.0.2")
+	}
+	server.port = server.conns[fakePrimaryIp.String()+"0"].LocalAddr().(*net.UDPAddr).Port
+	var wg sync.WaitGroup
+	for _, conn := range server.conns {
+		wg.Add(1)
+		go func(conn *net.UDPConn) {
+			defer wg.Done()
+			server.serve(conn)
+		}(conn)
+	}
+	t.Cleanup(func() {
+		for _, conn := range server.conns {
+			conn.Close()
+		}
+		wg.Wait()
+	})
+	return server
+}
+
+// Listens on a free port and the one after it, on both IP addresses.
+func listenFakeServer(t *testing.T) map[string]*net.UDPConn {
+	first, err := net.ListenUDP("udp4", &net.UDPAddr{IP: fakePrimaryIp})
+	if err != nil {
+		t.Skip(err)
+	}
+	port := first.LocalAddr().(*net.UDPAddr).Port
+	conns := map[string]*net.UDPConn{fakePrimaryIp.String() + "0": first}
+	for _, ip := range []net.IP{fakePrimaryIp, fakeAlternateIp} {
+		for i := 0; i < 2; i++ {
+			key := fmt.Sprintf("%v%d", ip, i)
+			if _, ok := conns[key]; ok {
+				continue
+			}
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip, Port: port + i})
+			if err != nil {
+				for _, conn := range conns {
+					conn.Close()
+				}
+				return nil
+			}
+			conns[key] = conn
+		}
+	}
+	return conns
+}
+
+// The address of the server on ip, on the primary port or the other one.
+func (server *fakeServer) addr(ip net.IP, otherPort bool) *net.UDPAddr {
+	if otherPort {
+		return &net.UDPAddr{IP: ip, Port: server.port + 1}
+	}
+	return &net.UDPAddr{IP: ip, Port: server.port}
+}
+
+func (server *fakeServer) primary() *net.UDPAddr {
+	return server.addr(fakePrimaryIp, false)
+}
+
+func (server *fakeServer) serve(conn *net.UDPConn) {
+	on := conn.LocalAddr().(*net.UDPAddr)
+	buffer := make([]byte, receiveBufferSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		request := &Message{}
+		if request.Parse(append([]byte(nil), buffer[:n]...)) != nil || request.GetType() != BindingRequest {
+			continue
+		}
+		to := from
+		if port, ok := request.GetResponsePort(); ok {
+			to = &net.UDPAddr{IP: from.IP, Port: port}
+		}
+		server.mutex.Lock()
+		if server.contacted[from.String()] == nil {
+			server.contacted[from.String()] = map[string]bool{}
+		}
+		server.contacted[from.String()][on.String()] = true
+		server.lastSeen[from.String()] = time.Now()
+		expired := server.lifetime > 0 && time.Since(server.lastSeen[to.String()]) > server.lifetime
+		server.mutex.Unlock()
+		if expired || server.drop != nil && server.drop(request, on) {
+			continue
+		}
+
+		// Where the response comes from.
+		changeIp, changePort := false, false
+		if changeRequest := request.GetChangeRequest(); changeRequest != nil {
+			changeIp, changePort = changeRequest.IsChangeIp(), changeRequest.IsChangePort()
+		}
+		sourceIp := on.IP
+		if changeIp {
+			sourceIp = server.otherIp(on.IP)
+		}
+		source := server.addr(sourceIp, (on.Port != server.port) != changePort)
+		if !server.passes(from, source) {
+			continue
+		}
+
+		opts := []MessageOption{WithTransactionId(request.GetTransactionId()), WithResponseOrigin(source)}
+		messageType := BindingResponse
+		if server.errorCode != nil {
+			if errorCode := server.errorCode(request, on); errorCode != nil {
+				messageType = BindingErrorResponse
+				opts = []MessageOption{WithTransactionId(request.GetTransactionId()), WithErrorCode(errorCode)}
+			}
+		}
+		if messageType == BindingResponse {
+			if !server.noMappedAddress {
+				opts = append(opts, WithXorMappedAddress(server.mapped(from, on)))
+			}
+			if !server.noOtherAddress {
+				opts = append(opts, WithOtherAddress(server.addr(server.otherIp(on.IP), on.Port == server.port)))
+			}
+		}
+		if server.integrityKey != nil {
+			opts = append(opts, WithMessageIntegrity(server.integrityKey))
+		}
+		response, err := Build(messageType, opts...)
+		if err != nil {
+			panic(err)
+		}
+		server.conns[fmt.Sprintf("%v%d", source.IP, source.Port-server.port)].WriteToUDP(response.ToByteData(), to)
+	}
+}
+
+func (server *fakeServer) otherIp(ip net.IP) net.IP {
+	if ip.Equal(fakePrimaryIp) {
+		return fakeAlternateIp
+	}
+	return fakePrimaryIp
+}
+
+// The mapped address the NAT gives from when it sends to on.
+func (server *fakeServer) mapped(from *net.UDPAddr, on *net.UDPAddr) *net.UDPAddr {
+	natIp := server.natIp
+	if natIp == nil {
+		natIp = fakeNatIp
+	}
+	switch server.nat.mapping {
+	case EndpointIndependentMapping:
+		return &net.UDPAddr{IP: natIp, Port: from.Port}
+	case AddressDependentMapping:
+		return &net.UDPAddr{IP: natIp, Port: from.Port + int(on.IP[3])*1000}
+	case AddressAndPortDependentMapping:
+		return &net.UDPAddr{IP: natIp, Port: from.Port + int(on.IP[3])*1000 + on.Port - server.port}
+	}
+	return from
+}
+
+// Tells whether the NAT lets a response from source through to client.
+func (server *fakeServer) passes(client *net.UDPAddr, source *net.UDPAddr) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	switch server.nat.filtering {
+	case AddressDependentFiltering:
+		for contacted := range server.contacted[client.String()] {
+			if host, _, _ := net.SplitHostPort(contacted); host == source.IP.String() {
+				return true
+			}
+		}
+		return false
+	case AddressAndPortDependentFiltering:
+		return server.contacted[client.String()][source.String()]
+	}
+	return true
+}
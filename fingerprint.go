@@ -0,0 +1,42 @@
+package stun
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// Size of the FINGERPRINT attribute, header included.
+const fingerprintSize = 4 + 4
+
+// XOR'ed with the CRC-32 so that FINGERPRINT differs from CRC-32s that other
+// protocols sharing the port put at the end of their packets.
+const fingerprintXor = 0x5354554E
+
+// CRC-32 of data, the message up to but excluding FINGERPRINT, with the
+// length in the header already counting FINGERPRINT.
+func fingerprint(data []byte) uint32 {
+	/* RFC 5389 15.5.
+	   The value of the attribute is computed as the CRC-32 of the STUN message
+	   up to (but excluding) the FINGERPRINT attribute itself, XOR'ed with
+	   the 32-bit value 0x5354554e.
+	*/
+	return crc32.ChecksumIEEE(data) ^ fingerprintXor
+}
+
+// Appends FINGERPRINT, replacing any previous one. It has to be the last
+// attribute, so add it after everything else including MESSAGE-INTEGRITY.
+func (message *Message) AddFingerprint() {
+	message.RemoveAttribute(Fingerprint)
+	data := message.encode(message.attributes)
+	binary.BigEndian.PutUint16(data[2:], uint16(len(data)-20+fingerprintSize))
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, fingerprint(data))
+	message.AddAttribute(Fingerprint, value)
+}
+
+// Reports whether the message carries FINGERPRINT. Parse has already checked
+// that it matches.
+func (message *Message) HasFingerprint() bool {
+	_, ok := message.GetAttribute(Fingerprint)
+	return ok
+}
@@ -0,0 +1,89 @@
+package stun
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Returns a one-line summary: the type, the transaction ID and the attribute
+// types. Use %+v for a full dump.
+func (message *Message) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s id=%x", message.messageType, message.transactionId)
+	for _, attribute := range message.attributes {
+		b.WriteByte(' ')
+		b.WriteString(attribute.Type.String())
+	}
+	return b.String()
+}
+
+// Implements fmt.Formatter. %v and %s print String, %+v prints a dump in the
+// manner of Wireshark's STUN dissector, and %x prints the encoded message in hex.
+func (message *Message) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if f.Flag('+') {
+			message.dump(f)
+			return
+		}
+		io.WriteString(f, message.String())
+	case 's':
+		io.WriteString(f, message.String())
+	case 'x':
+		io.WriteString(f, hex.EncodeToString(message.ToByteData()))
+	default:
+		fmt.Fprintf(f, "%%!%c(*stun.Message=%s)", verb, message.String())
+	}
+}
+
+// Writes the header fields, then every attribute with its offset in the
+// encoded message, its length and its decoded value.
+func (message *Message) dump(w io.Writer) {
+	length := 0
+	for _, attribute := range message.attributes {
+		length += 4 + len(attribute.Value) + paddingLength(len(attribute.Value))
+	}
+
+	fmt.Fprintf(w, "Session Traversal Utilities for NAT\n")
+	fmt.Fprintf(w, "    Message Type: 0x%04X (%s)\n", uint16(message.messageType), message.messageType)
+	fmt.Fprintf(w, "        Message Class: 0x%02X (%s)\n", uint8(message.messageType.Class()), message.messageType.Class())
+	fmt.Fprintf(w, "        Message Method: 0x%03X (%s)\n", uint16(message.messageType.Method()), message.messageType.Method())
+	fmt.Fprintf(w, "    Message Length: %d\n", length)
+	fmt.Fprintf(w, "    Message Cookie: 0x%08X\n", uint32(message.magicCookie))
+	fmt.Fprintf(w, "    Message Transaction ID: %x\n", message.transactionId)
+	fmt.Fprintf(w, "    Attributes\n")
+
+	offset := 20
+	for _, attribute := range message.attributes {
+		name := "Unknown"
+		if _, ok := attributeTypeNames[attribute.Type]; ok {
+			name = attribute.Type.String()
+		}
+		fmt.Fprintf(w, "        [%d] %s (0x%04X) length %d: %s\n",
+			offset, name, uint(attribute.Type), len(attribute.Value), message.formatAttribute(attribute))
+		offset += 4 + len(attribute.Value) + paddingLength(len(attribute.Value))
+	}
+}
+
+func (message *Message) formatAttribute(attribute Attribute) string {
+	switch value := message.decodeAttribute(attribute).(type) {
+	case jsonChangeRequest:
+		return fmt.Sprintf("change IP %t, change port %t", value.ChangeIp, value.ChangePort)
+	case jsonErrorCode:
+		return fmt.Sprintf("%d %s", value.Code, value.Reason)
+	case int:
+		return fmt.Sprint(value)
+	case []string:
+		return strings.Join(value, ", ")
+	case string:
+		switch attribute.Type {
+		case Username, Password, Realm, Nonce, Software:
+			return fmt.Sprintf("%q", value)
+		}
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
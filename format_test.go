@@ -0,0 +1,82 @@
+package stun
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFormatRFC5769Request(t *testing.T) {
+	message := NewStunMessage()
+	if err := message.Parse(decodeSeed(rfc5769Request)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"%v", "BindingRequest id=b7e7a701bc34d686fa87dfae Software 0x0024 0x8029 Username MessageIntegrity Fingerprint"},
+		{"%s", "BindingRequest id=b7e7a701bc34d686fa87dfae Software 0x0024 0x8029 Username MessageIntegrity Fingerprint"},
+		// The attributes unknown to us are PRIORITY and ICE-CONTROLLED of ICE.
+		{"%+v", `Session Traversal Utilities for NAT
+    Message Type: 0x0001 (BindingRequest)
+        Message Class: 0x00 (Request)
+        Message Method: 0x001 (Binding)
+    Message Length: 88
+    Message Cookie: 0x2112A442
+    Message Transaction ID: b7e7a701bc34d686fa87dfae
+    Attributes
+        [20] Software (0x8022) length 16: "STUN test client"
+        [40] Unknown (0x0024) length 4: 6e0001ff
+        [48] Unknown (0x8029) length 8: 932ff9b151263b36
+        [60] Username (0x0006) length 9: "evtj:h6vY"
+        [76] MessageIntegrity (0x0008) length 20: 9aeaa70cbfd8cb56781ef2b5b2d3f249c1b571a2
+        [100] Fingerprint (0x8028) length 4: e57a3bcf
+`},
+		{"%x", "000100582112a442b7e7a701bc34d686fa87dfae802200105354554e207465737420636c69656e74" +
+			"002400046e0001ff80290008932ff9b151263b36000600096576746a3a68367659202020" +
+			"000800149aeaa70cbfd8cb56781ef2b5b2d3f249c1b571a280280004e57a3bcf"},
+	}
+	for _, test := range tests {
+		if got := fmt.Sprintf(test.format, message); got != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.format, got, test.want)
+		}
+	}
+}
+
+func TestFormatPaddedAttributes(t *testing.T) {
+	// Values of 5, 3 and 2 bytes, padded to 8, 4 and 4.
+	message, err := Build(BindingRequest,
+		WithTransactionId([]byte("0123456789ab")),
+		WithSoftware("abcde"),
+		WithAttribute(0x8050, []byte{0xca, 0xfe, 0x01}),
+		WithAttribute(Password, []byte("pw")),
+		WithResponsePort(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Session Traversal Utilities for NAT
+    Message Type: 0x0001 (BindingRequest)
+        Message Class: 0x00 (Request)
+        Message Method: 0x001 (Binding)
+    Message Length: 36
+    Message Cookie: 0x2112A442
+    Message Transaction ID: 303132333435363738396162
+    Attributes
+        [20] Software (0x8022) length 5: "abcde"
+        [32] Unknown (0x8050) length 3: cafe01
+        [40] Password (0x0007) length 2: "pw"
+        [48] ResponsePort (0x0027) length 4: 1000
+`
+	if got := fmt.Sprintf("%+v", message); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	wantHex := "000100242112a442303132333435363738396162802200056162636465000000" +
+		"80500003cafe010000070002707700000027000403e80000"
+	if got := fmt.Sprintf("%x", message); got != wantHex {
+		t.Errorf("%%x: got %s, want %s", got, wantHex)
+	}
+	wantBad := "%!d(*stun.Message=BindingRequest id=303132333435363738396162 Software 0x8050 Password ResponsePort)"
+	if got := fmt.Sprintf("%d", message); got != wantBad {
+		t.Errorf("%%d: got %s, want %s", got, wantBad)
+	}
+}
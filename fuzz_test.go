@@ -0,0 +1,42 @@
+//go:build go1.18
+// +build go1.18
+
+package stun
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {
+	// The RFC 5769 sample messages, besides the packets captured from
+	// browsers in testdata/fuzz/FuzzParse.
+	for _, seed := range []string{rfc5769Request, rfc5769IPv4Response, rfc5769IPv6Response, rfc5769LongTermRequest} {
+		f.Add(decodeSeed(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		message := NewStunMessage()
+		if err := message.Parse(data); err != nil {
+			return
+		}
+
+		// None of the accessors may panic on a message Parse accepted.
+		message.GetMappedAddress()
+		message.GetXorMappedAddress()
+		message.GetResponseAddress()
+		message.GetSourceAddress()
+		message.GetChangedAddress()
+		message.GetResponseOrigin()
+		message.GetOtherAddress()
+		message.GetResponsePort()
+		message.GetChangeRequest()
+		message.GetErrorCode()
+
+		// A parsed message re-encodes byte-for-byte.
+		length := 20 + int(binary.BigEndian.Uint16(data[2:]))
+		if encoded := message.ToByteData(); !bytes.Equal(encoded, data[:length]) {
+			t.Fatalf("ToByteData() = %x, want %x", encoded, data[:length])
+		}
+	})
+}
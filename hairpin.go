@@ -0,0 +1,32 @@
+package stun
+
+import (
+	"context"
+	"net"
+)
+
+// Sends a Binding Request from a second socket to mappedAddr, the mapping of
+// the socket of agent, and tells whether the NAT loops it back to agent.
+func (client *Client) testHairpinning(ctx context.Context, agent *Agent, mappedAddr *net.UDPAddr) (HairpinningBehavior, error) {
+	hairpinAgent, release, err := client.listenAnyPort()
+	if err != nil {
+		return HairpinningUnknown, err
+	}
+	defer release()
+
+	request, err := client.newMessage(BindingRequest, nil)
+	if err != nil {
+		return HairpinningUnknown, err
+	}
+	// The request itself arrives, not a response to it.
+	received, _, err := client.retransmit(ctx, TestHairpinning, request, hairpinAgent.socket, agent, mappedAddr, func(received *Message, from net.Addr) bool {
+		return received.GetType() == BindingRequest
+	})
+	if err != nil {
+		return HairpinningUnknown, err
+	}
+	if received == nil {
+		return NoHairpinning, nil
+	}
+	return Hairpinning, nil
+}
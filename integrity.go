@@ -0,0 +1,64 @@
+package stun
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+)
+
+// Size of the MESSAGE-INTEGRITY attribute, header included.
+const messageIntegritySize = 4 + sha1.Size
+
+// Returns the key for short-term credentials, which is the password itself.
+// The password is used as is; callers must apply SASLprep if they need it.
+func ShortTermKey(password string) []byte {
+	return []byte(password)
+}
+
+// Returns the key for long-term credentials, MD5(username ":" realm ":" password).
+func LongTermKey(username string, realm string, password string) []byte {
+	sum := md5.Sum([]byte(username + ":" + realm + ":" + password))
+	return sum[:]
+}
+
+// HMAC-SHA1 over the header and the attributes preceding MESSAGE-INTEGRITY.
+func (message *Message) integrity(attributes []Attribute, key []byte) []byte {
+	/* RFC 5389 15.4.
+	   The text used as input to HMAC is the STUN message, including the
+	   header, up to and including the attribute preceding the
+	   MESSAGE-INTEGRITY attribute.  With the exception of the FINGERPRINT
+	   attribute, which appears after MESSAGE-INTEGRITY, agents MUST ignore
+	   all other attributes that follow MESSAGE-INTEGRITY.
+	   ...
+	   the length field of the STUN message header is adjusted to point to
+	   the end of the MESSAGE-INTEGRITY attribute.
+	*/
+	data := message.encode(attributes)
+	binary.BigEndian.PutUint16(data[2:], uint16(len(data)-20+messageIntegritySize))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Appends MESSAGE-INTEGRITY computed with key over the attributes added so
+// far, replacing any previous one and dropping FINGERPRINT, which has to be
+// added again afterwards. Attributes added afterwards are not covered.
+func (message *Message) AddMessageIntegrity(key []byte) {
+	message.RemoveAttribute(MessageIntegrity)
+	message.RemoveAttribute(Fingerprint)
+	message.AddAttribute(MessageIntegrity, message.integrity(message.attributes, key))
+}
+
+// Checks the MESSAGE-INTEGRITY of a parsed message against key.
+func (message *Message) Verify(key []byte) error {
+	for i, attribute := range message.attributes {
+		if attribute.Type == MessageIntegrity {
+			if !hmac.Equal(message.integrity(message.attributes[:i], key), attribute.Value) {
+				return ErrIntegrityMismatch
+			}
+			return nil
+		}
+	}
+	return ErrNoMessageIntegrity
+}
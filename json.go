@@ -0,0 +1,109 @@
+package stun
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Implements encoding.BinaryMarshaler.
+func (message *Message) MarshalBinary() ([]byte, error) {
+	return message.ToByteData(), nil
+}
+
+// Implements encoding.BinaryUnmarshaler. Unlike Parse it copies data.
+func (message *Message) UnmarshalBinary(data []byte) error {
+	return message.Parse(append([]byte(nil), data...))
+}
+
+type jsonMessage struct {
+	Type          string          `json:"type"`
+	Method        string          `json:"method"`
+	Class         string          `json:"class"`
+	MagicCookie   string          `json:"magicCookie"`
+	TransactionId string          `json:"transactionId"`
+	Attributes    []jsonAttribute `json:"attributes"`
+}
+
+type jsonAttribute struct {
+	Type   string      `json:"type"`
+	Length int         `json:"length"`
+	Value  interface{} `json:"value"`
+}
+
+type jsonChangeRequest struct {
+	ChangeIp   bool `json:"changeIp"`
+	ChangePort bool `json:"changePort"`
+}
+
+type jsonErrorCode struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// Renders the message with every attribute decoded, for structured logs.
+// Attributes this package does not know are rendered in hex.
+func (message *Message) MarshalJSON() ([]byte, error) {
+	magicCookie := make([]byte, 4)
+	binary.BigEndian.PutUint32(magicCookie, uint32(message.magicCookie))
+
+	attributes := make([]jsonAttribute, 0, len(message.attributes))
+	for _, attribute := range message.attributes {
+		attributes = append(attributes, jsonAttribute{
+			Type:   attribute.Type.String(),
+			Length: len(attribute.Value),
+			Value:  message.decodeAttribute(attribute),
+		})
+	}
+	return json.Marshal(jsonMessage{
+		Type:          message.messageType.String(),
+		Method:        message.messageType.Method().String(),
+		Class:         message.messageType.Class().String(),
+		MagicCookie:   hex.EncodeToString(magicCookie),
+		TransactionId: hex.EncodeToString(message.transactionId),
+		Attributes:    attributes,
+	})
+}
+
+// Returns the decoded value of an attribute of the message: an address as
+// "ip:port", a string, a struct, a list of types, or the raw value in hex.
+func (message *Message) decodeAttribute(attribute Attribute) interface{} {
+	switch attribute.Type {
+	case MappedAddress, ResponseAddress, SourceAddress, ChangedAddress, ReflectedFrom, ResponseOrigin, OtherAddress:
+		if addr := parseIPAddr(attribute.Value, 0); addr != nil {
+			return addr.String()
+		}
+	case XorMappedAddress:
+		if addr := parseIPAddr(attribute.Value, 0); addr != nil {
+			return xorIPAddr(addr, message.magicCookie, message.transactionId).String()
+		}
+	case Username, Password, Realm, Nonce, Software:
+		return string(attribute.Value)
+	case ResponsePort:
+		if port, ok := parseResponsePort(attribute.Value); ok {
+			return port
+		}
+	case ChangeRequest:
+		if request := parseChangeRequest(attribute.Value); request != nil {
+			return jsonChangeRequest{
+				ChangeIp:   request.IsChangeIp(),
+				ChangePort: request.IsChangePort(),
+			}
+		}
+	case ErrorCode:
+		if errorCode := parseErrorCode(attribute.Value); errorCode != nil {
+			return jsonErrorCode{
+				Code:   errorCode.GetCode(),
+				Reason: errorCode.GetReasonText(),
+			}
+		}
+	case UnknownAttribute:
+		types := (&Message{attributes: []Attribute{attribute}}).GetUnknownAttributes()
+		names := make([]string, len(types))
+		for i, t := range types {
+			names[i] = t.String()
+		}
+		return names
+	}
+	return hex.EncodeToString(attribute.Value)
+}
@@ -0,0 +1,89 @@
+package stun
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	message := NewStunMessage()
+	if err := message.Parse(decodeSeed(rfc5769IPv4Response)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"BindingSuccessResponse","method":"Binding","class":"SuccessResponse",` +
+		`"magicCookie":"2112a442","transactionId":"b7e7a701bc34d686fa87dfae","attributes":[` +
+		`{"type":"Software","length":11,"value":"test vector"},` +
+		`{"type":"XorMappedAddress","length":8,"value":"192.0.2.1:32853"},` +
+		`{"type":"MessageIntegrity","length":20,"value":"2b91f599fd9e90c38c7489f92af9ba53f06be7d7"},` +
+		`{"type":"Fingerprint","length":4,"value":"c07d4c96"}]}`
+	if string(got) != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestMarshalJSONDecodesEachAttribute(t *testing.T) {
+	// Two RESPONSE-PORT attributes: each renders its own port, not the first.
+	message := NewStunMessage2(BindingRequest, NewStunChangeRequest(true, false))
+	message.AddAttribute(ResponsePort, []byte{0x03, 0xe8, 0, 0})
+	message.AddAttribute(ResponsePort, []byte{0x07, 0xd0, 0, 0})
+	message.AddAttribute(0x8050, []byte{0xca, 0xfe})
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Attributes []struct {
+			Type  string      `json:"type"`
+			Value interface{} `json:"value"`
+		} `json:"attributes"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{map[string]interface{}{"changeIp": true, "changePort": false}, 1000.0, 2000.0, "cafe"}
+	if len(got.Attributes) != len(want) {
+		t.Fatalf("got %s", data)
+	}
+	for i, attribute := range got.Attributes {
+		if gotValue, _ := json.Marshal(attribute.Value); !bytes.Equal(gotValue, mustMarshal(t, want[i])) {
+			t.Errorf("attribute %d (%s): got %s, want %s", i, attribute.Type, gotValue, mustMarshal(t, want[i]))
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestUnmarshalBinary(t *testing.T) {
+	data := decodeSeed(rfc5769IPv4Response)
+	message := NewStunMessage()
+	if err := message.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	// The message keeps its own copy.
+	for i := range data {
+		data[i] = 0
+	}
+	if got := message.GetSoftware(); got != "test vector" {
+		t.Errorf("GetSoftware() = %q after the input changed, want %q", got, "test vector")
+	}
+
+	marshaled, err := message.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := decodeSeed(rfc5769IPv4Response); !bytes.Equal(marshaled, want) {
+		t.Errorf("MarshalBinary() = %x, want %x", marshaled, want)
+	}
+}
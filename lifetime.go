@@ -0,0 +1,162 @@
+package stun
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Reported by MeasureLifetime after every probe.
+type LifetimeProgress struct {
+	// How long the probed mapping was left idle.
+	Interval time.Duration
+
+	// Whether the mapping was still alive after Interval.
+	Alive bool
+
+	// The longest interval a mapping is known to survive, and the shortest
+	// one it is known not to survive; 0 if not known yet.
+	Lower time.Duration
+	Upper time.Duration
+}
+
+// Measures how long the NAT keeps an idle UDP mapping, as described in
+// RFC 5780 4.6. The server must support RESPONSE-PORT. Every probe opens a
+// mapping with a fresh socket X, leaves it idle, then asks the server, from a
+// second socket Y, to answer to X's mapped port; the mapping is alive if X
+// receives the answer. The interval grows exponentially from the lower bound
+// until a mapping dies, then the lifetime is binary-searched down to the
+// precision. See WithLifetimeBounds, WithLifetimePrecision and
+// WithLifetimeProgress.
+//
+// A measurement runs for many times the lifetime; cancel ctx to stop it.
+func MeasureLifetime(ctx context.Context, stun string, local string, opts ...ClientOption) (time.Duration, error) {
+	client, err := NewClient(stun, local, opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+	return client.MeasureLifetime(ctx)
+}
+
+func MeasureLifetime2(ctx context.Context, stunAddr *net.UDPAddr, localAddr *net.UDPAddr, opts ...ClientOption) (time.Duration, error) {
+	client := NewClient2(stunAddr, localAddr, opts...)
+	defer client.Close()
+	return client.MeasureLifetime(ctx)
+}
+
+// See the package-level MeasureLifetime.
+func (client *Client) MeasureLifetime(ctx context.Context) (time.Duration, error) {
+	if client.lifetimeMin <= 0 || client.lifetimeMax < client.lifetimeMin || client.lifetimePrecision <= 0 {
+		return 0, ErrInvalidLifetimeBounds
+	}
+
+	// Y keeps its mapping alive by sending a request every probe.
+	agent, release, err := client.listen()
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	// Make sure responses to RESPONSE-PORT arrive at all.
+	alive, err := client.probeLifetime(ctx, agent, 0)
+	if err != nil {
+		return 0, err
+	}
+	if !alive {
+		return 0, ErrResponsePortFailed
+	}
+
+	return searchLifetime(client.lifetimeMin, client.lifetimeMax, client.lifetimePrecision, func(interval time.Duration) (bool, error) {
+		return client.probeLifetime(ctx, agent, interval)
+	}, client.lifetimeProgress)
+}
+
+// Finds the longest interval in [min, max] that probe reports alive, within
+// precision: the interval grows exponentially from min until a mapping dies,
+// then it is binary-searched. Returns 0 if min is already too long. The
+// bounds must be validated by the caller.
+func searchLifetime(min time.Duration, max time.Duration, precision time.Duration, probe func(interval time.Duration) (bool, error), progress func(progress LifetimeProgress)) (time.Duration, error) {
+	var lower, upper time.Duration
+	try := func(interval time.Duration) error {
+		alive, err := probe(interval)
+		if err != nil {
+			return err
+		}
+		if alive {
+			lower = interval
+		} else {
+			upper = interval
+		}
+		if progress != nil {
+			progress(LifetimeProgress{Interval: interval, Alive: alive, Lower: lower, Upper: upper})
+		}
+		return nil
+	}
+
+	// Grow the interval until a mapping dies.
+	for interval := min; upper == 0; interval *= 2 {
+		if interval >= max {
+			interval = max
+		}
+		if err := try(interval); err != nil {
+			return lower, err
+		}
+		if lower == max {
+			return lower, nil
+		}
+	}
+
+	// The mapping does not outlive min: nothing below it is probed.
+	if lower == 0 {
+		return 0, nil
+	}
+
+	// Binary search between the longest alive and the shortest dead interval.
+	for upper-lower > precision {
+		if err := try(lower + (upper-lower)/2); err != nil {
+			return lower, err
+		}
+	}
+	return lower, nil
+}
+
+// Opens a mapping from a fresh socket, leaves it idle for interval, and tells
+// whether a response sent from agent with RESPONSE-PORT still reaches it.
+func (client *Client) probeLifetime(ctx context.Context, agent *Agent, interval time.Duration) (bool, error) {
+	probeAgent, release, err := client.listenAnyPort()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	response, err := client.transact(ctx, TestI, BindingRequest, nil, probeAgent, client.serverAddr)
+	if err != nil {
+		return false, err
+	}
+	if response == nil {
+		return false, ErrNoResponse
+	}
+	mappedAddr := response.getPublicAddress()
+	if mappedAddr == nil {
+		return false, ErrNoMappedAddress
+	}
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-timer.C:
+	}
+
+	request, err := client.newMessage(BindingRequest, nil, WithResponsePort(mappedAddr.Port))
+	if err != nil {
+		return false, err
+	}
+	response, err = client.doTransactionVia(ctx, TestLifetime, request, agent.socket, probeAgent, client.serverAddr)
+	if err != nil {
+		return false, err
+	}
+	return response != nil, nil
+}
@@ -0,0 +1,139 @@
+package stun
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSearchLifetime(t *testing.T) {
+	const (
+		min       = 50 * time.Millisecond
+		max       = 5 * time.Second
+		precision = 20 * time.Millisecond
+	)
+	for _, lifetime := range []time.Duration{10 * time.Millisecond, min, 287 * time.Millisecond, 3 * time.Second, max, time.Minute} {
+		var probed []time.Duration
+		got, err := searchLifetime(min, max, precision, func(interval time.Duration) (bool, error) {
+			probed = append(probed, interval)
+			return interval <= lifetime, nil
+		}, nil)
+		if err != nil {
+			t.Fatalf("lifetime %v: %v", lifetime, err)
+		}
+		for _, interval := range probed {
+			if interval < min || interval > max {
+				t.Errorf("lifetime %v: probed %v outside [%v, %v]", lifetime, interval, min, max)
+			}
+		}
+		switch {
+		case lifetime < min:
+			if got != 0 {
+				t.Errorf("lifetime %v: got %v, want 0", lifetime, got)
+			}
+		case lifetime >= max:
+			if got != max {
+				t.Errorf("lifetime %v: got %v, want %v", lifetime, got, max)
+			}
+		default:
+			if got > lifetime || lifetime-got > precision {
+				t.Errorf("lifetime %v: got %v, want within %v below", lifetime, got, precision)
+			}
+		}
+	}
+}
+
+func TestSearchLifetimeProgressAndError(t *testing.T) {
+	errProbe := errors.New("probe failed")
+	var progress []LifetimeProgress
+	got, err := searchLifetime(time.Second, time.Minute, time.Second, func(interval time.Duration) (bool, error) {
+		if interval > 2*time.Second {
+			return false, errProbe
+		}
+		return true, nil
+	}, func(p LifetimeProgress) {
+		progress = append(progress, p)
+	})
+	if err != errProbe {
+		t.Fatalf("got error %v, want %v", err, errProbe)
+	}
+	if got != 2*time.Second {
+		t.Errorf("got %v, want the last alive interval 2s", got)
+	}
+	want := []LifetimeProgress{
+		{Interval: time.Second, Alive: true, Lower: time.Second},
+		{Interval: 2 * time.Second, Alive: true, Lower: 2 * time.Second},
+	}
+	if len(progress) != len(want) {
+		t.Fatalf("got progress %+v, want %+v", progress, want)
+	}
+	for i := range want {
+		if progress[i] != want[i] {
+			t.Errorf("progress %d: got %+v, want %+v", i, progress[i], want[i])
+		}
+	}
+}
+
+func TestMeasureLifetimeInvalidBounds(t *testing.T) {
+	for _, opts := range [][]ClientOption{
+		{WithLifetimeBounds(0, time.Minute)},
+		{WithLifetimeBounds(time.Minute, time.Second)},
+		{WithLifetimePrecision(0)},
+	} {
+		_, err := MeasureLifetime(context.Background(), "127.0.0.1:3478", "127.0.0.1:0", opts...)
+		if err != ErrInvalidLifetimeBounds {
+			t.Errorf("got %v, want %v", err, ErrInvalidLifetimeBounds)
+		}
+	}
+}
+
+func TestMeasureLifetime(t *testing.T) {
+	const (
+		lifetime  = 150 * time.Millisecond
+		precision = 20 * time.Millisecond
+	)
+	server := startFakeServer(t, &fakeServer{nat: fakeNat{EndpointIndependentMapping, EndpointIndependentFiltering}, lifetime: lifetime})
+	var progress []LifetimeProgress
+	client := newFakeClient(server,
+		WithLifetimeBounds(20*time.Millisecond, time.Second),
+		WithLifetimePrecision(precision),
+		WithLifetimeProgress(func(p LifetimeProgress) {
+			progress = append(progress, p)
+		}),
+		WithTestTimeout(TestLifetime, 100*time.Millisecond))
+	defer client.Close()
+
+	got, err := client.MeasureLifetime(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Probes are late by the scheduling delay, so one just below the
+	// lifetime may die.
+	if got > lifetime || got < lifetime-3*precision {
+		t.Errorf("got %v, want within %v below %v; progress %+v", got, 3*precision, lifetime, progress)
+	}
+	if len(progress) == 0 || progress[len(progress)-1].Lower != got {
+		t.Errorf("got progress %+v, want it to end at %v", progress, got)
+	}
+}
+
+func TestMeasureLifetimeResponsePortFailed(t *testing.T) {
+	server := startFakeServer(t, &fakeServer{
+		nat: fakeNat{EndpointIndependentMapping, EndpointIndependentFiltering},
+		drop: func(request *Message, on *net.UDPAddr) bool {
+			_, ok := request.GetResponsePort()
+			return ok
+		},
+	})
+	client := newFakeClient(server,
+		WithLifetimeBounds(20*time.Millisecond, time.Second),
+		WithLifetimePrecision(20*time.Millisecond),
+		WithTestTimeout(TestLifetime, 100*time.Millisecond))
+	defer client.Close()
+
+	if _, err := client.MeasureLifetime(context.Background()); !errors.Is(err, ErrResponsePortFailed) {
+		t.Errorf("got %v, want %v", err, ErrResponsePortFailed)
+	}
+}
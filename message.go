@@ -1,22 +1,27 @@
 package stun
 
 import (
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
-	"math"
+	"io"
 	"net"
+	"unicode/utf8"
 )
 
+// The fixed value every RFC 5389 message carries in its header, used to tell
+// RFC 5389 messages from RFC 3489 ones and as the XOR key of XOR-MAPPED-ADDRESS.
+const MagicCookie = 0x2112A442
+
 type Message struct {
-	transactionId   []byte
-	messageType     MessageType
-	magicCookie     int
-	mappedAddress   *net.UDPAddr
-	responseAddress *net.UDPAddr
-	sourceAddress   *net.UDPAddr
-	changedAddress  *net.UDPAddr
-	changeRequest   *Request
-	errorCode       *Code
+	transactionId []byte
+	messageType   MessageType
+	magicCookie   int
+	attributes    []Attribute
+
+	// Backs transactionId when it is generated rather than parsed, so that
+	// generating one does not allocate.
+	transactionIdBuffer [12]byte
 }
 
 func (message *Message) GetTransactionId() []byte {
@@ -31,36 +36,203 @@ func (message *Message) GetMagicCookie() int {
 	return message.magicCookie
 }
 
+func (message *Message) getAddress(attributeType AttributeType) *net.UDPAddr {
+	if attribute, ok := message.GetAttribute(attributeType); ok {
+		return parseIPAddr(attribute.Value, 0)
+	}
+	return nil
+}
+
+// Sets an address attribute, or removes it if addr is nil or has no valid
+// IPv4 or IPv6 address, which cannot be encoded.
+func (message *Message) setAddress(attributeType AttributeType, addr *net.UDPAddr) {
+	if addr == nil || addr.IP.To16() == nil {
+		message.RemoveAttribute(attributeType)
+		return
+	}
+	message.SetAttribute(attributeType, storeEndPoint(addr))
+}
+
 func (message *Message) GetMappedAddress() *net.UDPAddr {
-	return message.mappedAddress
+	return message.getAddress(MappedAddress)
+}
+
+func (message *Message) SetMappedAddress(mappedAddress *net.UDPAddr) {
+	message.setAddress(MappedAddress, mappedAddress)
+}
+
+func (message *Message) GetXorMappedAddress() *net.UDPAddr {
+	if addr := message.getAddress(XorMappedAddress); addr != nil {
+		return xorIPAddr(addr, message.magicCookie, message.transactionId)
+	}
+	return nil
+}
+
+// Must be called after the transaction ID is set, as it is part of the XOR key.
+func (message *Message) SetXorMappedAddress(xorMappedAddress *net.UDPAddr) {
+	if xorMappedAddress != nil {
+		xorMappedAddress = xorIPAddr(xorMappedAddress, message.magicCookie, message.transactionId)
+	}
+	message.setAddress(XorMappedAddress, xorMappedAddress)
+}
+
+// Returns XOR-MAPPED-ADDRESS if the server sent one, otherwise MAPPED-ADDRESS.
+// XOR-MAPPED-ADDRESS survives NAT ALGs that rewrite plain addresses in payloads.
+func (message *Message) getPublicAddress() *net.UDPAddr {
+	if addr := message.GetXorMappedAddress(); addr != nil {
+		return addr
+	}
+	return message.GetMappedAddress()
 }
 
 func (message *Message) GetResponseAddress() *net.UDPAddr {
-	return message.responseAddress
+	return message.getAddress(ResponseAddress)
+}
+
+func (message *Message) SetResponseAddress(responseAddress *net.UDPAddr) {
+	message.setAddress(ResponseAddress, responseAddress)
 }
 
 func (message *Message) GetSourceAddress() *net.UDPAddr {
-	return message.sourceAddress
+	return message.getAddress(SourceAddress)
+}
+
+func (message *Message) SetSourceAddress(sourceAddress *net.UDPAddr) {
+	message.setAddress(SourceAddress, sourceAddress)
 }
 
 func (message *Message) GetChangedAddress() *net.UDPAddr {
-	return message.changedAddress
+	return message.getAddress(ChangedAddress)
+}
+
+func (message *Message) SetChangedAddress(changedAddress *net.UDPAddr) {
+	message.setAddress(ChangedAddress, changedAddress)
+}
+
+// RFC 5780 7.4: the address the response was sent from.
+func (message *Message) GetResponseOrigin() *net.UDPAddr {
+	return message.getAddress(ResponseOrigin)
+}
+
+func (message *Message) SetResponseOrigin(responseOrigin *net.UDPAddr) {
+	message.setAddress(ResponseOrigin, responseOrigin)
+}
+
+// RFC 5780 7.4: the alternate address and port of the server, the RFC 5389
+// successor of CHANGED-ADDRESS.
+func (message *Message) GetOtherAddress() *net.UDPAddr {
+	return message.getAddress(OtherAddress)
+}
+
+func (message *Message) SetOtherAddress(otherAddress *net.UDPAddr) {
+	message.setAddress(OtherAddress, otherAddress)
+}
+
+// Returns CHANGED-ADDRESS, or OTHER-ADDRESS from RFC 5780 servers, which no
+// longer send CHANGED-ADDRESS.
+func (message *Message) getAlternateAddress() *net.UDPAddr {
+	if addr := message.GetChangedAddress(); addr != nil {
+		return addr
+	}
+	return message.GetOtherAddress()
+}
+
+// RFC 5780 7.5: the port the server should send the response to, instead of
+// the source port of the request.
+func (message *Message) GetResponsePort() (int, bool) {
+	/*
+	    0                   1                   2                   3
+	    0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	   |             Port              |           Reserved            |
+	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	*/
+	attribute, ok := message.GetAttribute(ResponsePort)
+	if !ok {
+		return 0, false
+	}
+	return parseResponsePort(attribute.Value)
+}
+
+func parseResponsePort(value []byte) (int, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint16(value)), true
+}
+
+// Sets RESPONSE-PORT, or removes it if port is 0.
+func (message *Message) SetResponsePort(port int) {
+	if port == 0 {
+		message.RemoveAttribute(ResponsePort)
+		return
+	}
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint16(value, uint16(port))
+	message.SetAttribute(ResponsePort, value)
 }
 
 func (message *Message) GetChangeRequest() *Request {
-	return message.changeRequest
+	if attribute, ok := message.GetAttribute(ChangeRequest); ok {
+		return parseChangeRequest(attribute.Value)
+	}
+	return nil
+}
+
+func (message *Message) SetChangeRequest(changeRequest *Request) {
+	if changeRequest == nil {
+		message.RemoveAttribute(ChangeRequest)
+		return
+	}
+	message.SetAttribute(ChangeRequest, changeRequest.toByteData())
 }
 
 func (message *Message) GetErrorCode() *Code {
-	return message.errorCode
+	if attribute, ok := message.GetAttribute(ErrorCode); ok {
+		return parseErrorCode(attribute.Value)
+	}
+	return nil
 }
 
+// Sets ERROR-CODE. The code must be between 300 and 699 and the reason phrase
+// must be UTF-8 of fewer than 128 characters.
+func (message *Message) SetErrorCode(errorCode *Code) error {
+	if errorCode == nil {
+		message.RemoveAttribute(ErrorCode)
+		return nil
+	}
+	if errorCode.code < 300 || errorCode.code > 699 {
+		return ErrInvalidErrorCode
+	}
+	if !utf8.ValidString(errorCode.reasonText) {
+		return ErrInvalidUTF8
+	}
+	if len(errorCode.reasonText) > 763 || utf8.RuneCountInString(errorCode.reasonText) > 127 {
+		return ErrAttributeTooLong
+	}
+	message.SetAttribute(ErrorCode, errorCode.toByteData())
+	return nil
+}
+
+// Fills the transaction ID with 96 bits read from random. Transaction IDs must
+// be unpredictable, otherwise an off-path attacker can spoof responses.
+func (message *Message) NewTransactionId(random io.Reader) error {
+	if _, err := io.ReadFull(random, message.transactionIdBuffer[:]); err != nil {
+		return err
+	}
+	message.transactionId = message.transactionIdBuffer[:]
+	return nil
+}
+
+// Returns a message with a transaction ID from crypto/rand.
 func NewStunMessage() *Message {
 	message := &Message{
-		transactionId: make([]byte, 12),
+		magicCookie: MagicCookie,
+	}
+	if err := message.NewTransactionId(rand.Reader); err != nil {
+		// crypto/rand does not fail on any supported platform.
+		panic(err)
 	}
-	//rand.Read(message.transactionId)
-	copy(message.transactionId, "0123456789ab")
 	return message
 }
 
@@ -72,12 +244,24 @@ func NewStunMessage1(messageType MessageType) *Message {
 
 func NewStunMessage2(messageType MessageType, changeRequest *Request) *Message {
 	message := NewStunMessage1(messageType)
-	message.changeRequest = changeRequest
+	message.SetChangeRequest(changeRequest)
 	return message
 }
 
-// Parses STUN message from raw data packet.
-func (message *Message) Parse(data []byte) error {
+// Parses STUN message from raw data packet. FINGERPRINT is verified whenever
+// present. The message refers to data rather than copying it, so data must
+// not be modified while the message is in use.
+func (message *Message) Parse(data []byte, opts ...ParseOption) error {
+	return message.parse(data, newParseConfig(opts).requireFingerprint)
+}
+
+// Same as Parse without options. Together with AppendTo and the message pool
+// it lets the hot path reuse buffers and messages without allocating.
+func (message *Message) Decode(data []byte) error {
+	return message.parse(data, false)
+}
+
+func (message *Message) parse(data []byte, requireFingerprint bool) error {
 
 	if data == nil {
 		return errors.New("data is null")
@@ -103,392 +287,314 @@ func (message *Message) Parse(data []byte) error {
 	*/
 
 	if len(data) < 20 {
-		return errors.New("Invalid STUN message value !")
+		return ErrMessageTooShort
 	}
 
 	offset := 0
 
 	//--- message header --------------------------------------------------
 
-	// STUN Message Type
+	// STUN Message Type, the two most significant bits are always zero.
+	if data[offset]&0xC0 != 0 {
+		return ErrInvalidMessageType
+	}
 	messageType := MessageType(binary.BigEndian.Uint16(data[offset:]))
 	offset += 2
-	switch messageType {
-	case BindingErrorResponse, BindingRequest, BindingResponse, SharedSecretErrorResponse, SharedSecretRequest, SharedSecretResponse:
-		message.messageType = messageType
-	default:
-		return errors.New("Invalid STUN message type value !")
-	}
 
-	//        System.out.println("MessageType " + type);
 	// Message Length
-	//messageLength := int(data[offset])<<8 | int(data[offset+1])
 	messageLength := int(binary.BigEndian.Uint16(data[offset:]))
 	offset += 2
-	//        System.out.println("messageLength " + messageLength);
+	// All attributes are padded to a multiple of 4 bytes, so is the message.
+	if messageLength%4 != 0 {
+		return ErrInvalidMessageLength
+	}
+	if len(data) < 20+messageLength {
+		return ErrMessageTruncated
+	}
+	// Ignore anything after the message, e.g. the rest of a receive buffer.
+	data = data[:20+messageLength]
+
 	// Magic Cookie
-	//message.magicCookie = int(data[offset])<<24 | int(data[offset+1])<<16 | int(data[offset+2])<<8 | int(data[offset+3])
-	message.magicCookie = int(binary.BigEndian.Uint32(data[offset:]))
+	magicCookie := int(binary.BigEndian.Uint32(data[offset:]))
 	offset += 4
 
 	// Transaction ID
-	//message.transactionId = make([]byte, 12)
-	//copy(message.transactionId, data[offset:])
-	message.transactionId = data[offset : offset+12]
+	transactionId := data[offset : offset+12]
 	offset += 12
 
 	//--- Message attributes ---------------------------------------------
-	for offset-20 < messageLength {
-		//            System.out.println("offset " + offset);
-		/* RFC 3489 11.2.
-		    Each attribute is TLV encoded, with a 16 bit type, 16 bit length, and variable value:
+	// Collect the attributes in a local slice, on the stack unless there are
+	// many, so that the message is left as it was when the packet is rejected.
+	var local [maxLocalAttributes]Attribute
+	attributes, hasFingerprint, err := parseAttributes(data, offset, local[:0])
+	if err != nil {
+		return err
+	}
+	if requireFingerprint && !hasFingerprint {
+		return ErrNoFingerprint
+	}
+
+	message.messageType = messageType
+	message.magicCookie = magicCookie
+	message.transactionId = transactionId
+	message.attributes = append(message.attributes[:0], attributes...)
+	return nil
+}
+
+// Parse collects up to this many attributes without allocating.
+const maxLocalAttributes = 16
+
+// Parses the attributes of the message data, starting at offset, and appends
+// them to attributes. data must end with the message.
+func parseAttributes(data []byte, offset int, attributes []Attribute) ([]Attribute, bool, error) {
+	hasFingerprint := false
+	for offset < len(data) {
+		/* RFC 5389 15.
+		    Each attribute is TLV encoded, with a 16 bit type, 16 bit length, and variable value.
+		    The value is padded to a multiple of 4 bytes, the length does not include the padding:
 		    0                   1                   2                   3
 		    0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
 		   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 		   |         Type                  |            Length             |
 		   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-		   |                             Value                             ....
+		   |                         Value (variable)                ....
 		   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 		*/
+		start := offset
 
 		// Type
 		attributeType := AttributeType(binary.BigEndian.Uint16(data[offset:]))
 		offset += 2
+		// Length
 		length := int(binary.BigEndian.Uint16(data[offset:]))
 		offset += 2
-		switch attributeType {
-		case MappedAddress:
-			message.mappedAddress = parseIPAddr(data, offset)
-			offset += 8
-		case ResponseAddress:
-			// RESPONSE-ADDRESS
-			message.responseAddress = parseIPAddr(data, offset)
-			offset += 8
-		case ChangeRequest:
-			// CHANGE-REQUEST
-
-			/*
-			   The CHANGE-REQUEST attribute is used by the client to request that
-			   the server use a different address and/or port when sending the
-			   response.  The attribute is 32 bits long, although only two bits (A
-			   and B) are used:
-			    0                   1                   2                   3
-			    0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
-			   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-			   |0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 A B 0|
-			   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-			   The meaning of the flags is:
-			   A: This is the "change IP" flag.  If true, it requests the server
-			      to send the Binding Response with a different IP address than the
-			      one the Binding Request was received on.
-			   B: This is the "change port" flag.  If true, it requests the
-			      server to send the Binding Response with a different port than the
-			      one the Binding Request was received on.
-			*/
-
-			// Skip 3 bytes
-			offset += 3
-
-			message.changeRequest = NewStunChangeRequest((data[offset]&4) != 0, (data[offset]&2) != 0)
-			offset++
-		case SourceAddress:
-			// SOURCE-ADDRESS
-			message.sourceAddress = parseIPAddr(data, offset)
-			offset += 8
-		case ChangedAddress:
-			// CHANGED-ADDRESS
-			message.changedAddress = parseIPAddr(data, offset)
-			offset += 8
-		case MessageIntegrity:
-			// MESSAGE-INTEGRITY
-			offset += length
-		case ErrorCode:
-
-			// ERROR-CODE
-			/* 3489 11.2.9.
-			   0                   1                   2                   3
-			   0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
-			   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-			   |                   0                     |Class|     Number    |
-			   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-			   |      Reason Phrase (variable)                                ..
-			   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-			*/
-
-			code := int(data[offset+2]&0x7)*100 + int(data[offset+3])&0xFF
-
-			message.errorCode = NewStunErrorCode(code, string(data[offset+4:offset+length]))
-			offset += length
-		case UnknownAttribute:
-			// UNKNOWN-ATTRIBUTES
-			offset += length
-		default:
-			offset += length
+
+		end := offset + length
+		padded := end + paddingLength(length)
+		if padded > len(data) {
+			return nil, false, &AttributeError{Type: attributeType, Offset: start, Err: ErrAttributeTruncated}
+		}
+		attribute := Attribute{
+			Type:    attributeType,
+			Value:   data[offset:end],
+			padding: data[end:padded],
+		}
+		if err := validateAttribute(attribute); err != nil {
+			return nil, false, &AttributeError{Type: attributeType, Offset: start, Err: err}
+		}
+		if attributeType == Fingerprint {
+			if padded != len(data) {
+				return nil, false, &AttributeError{Type: attributeType, Offset: start, Err: ErrFingerprintNotLast}
+			}
+			if binary.BigEndian.Uint32(attribute.Value) != fingerprint(data[:start]) {
+				return nil, false, &AttributeError{Type: attributeType, Offset: start, Err: ErrFingerprintMismatch}
+			}
+			hasFingerprint = true
 		}
+		attributes = append(attributes, attribute)
+		offset = padded
 	}
-	return nil
+	return attributes, hasFingerprint, nil
 }
 
 func (message *Message) ToByteData() []byte {
+	return message.encode(message.attributes)
+}
+
+// Appends the encoded message to b and returns the extended slice. It does
+// not allocate when b has enough spare capacity.
+func (message *Message) AppendTo(b []byte) []byte {
+	return message.appendTo(b, message.attributes)
+}
+
+// Encodes the header and the given attributes, which are message.attributes
+// or a prefix of them.
+func (message *Message) encode(attributes []Attribute) []byte {
+	return message.appendTo(nil, attributes)
+}
 
-	msg := make([]byte, 512)
+func (message *Message) appendTo(b []byte, attributes []Attribute) []byte {
+
+	length := 20
+	for _, attribute := range attributes {
+		length += 4 + len(attribute.Value) + paddingLength(len(attribute.Value))
+	}
+	start := len(b)
+	if cap(b)-start < length {
+		grown := make([]byte, start, start+length)
+		copy(grown, b)
+		b = grown
+	}
+	b = b[:start+length]
+	msg := b[start:]
 
 	offset := 0
 
-	msg[offset] = byte((message.messageType >> 8) & 0x3F)
-	offset += 1
-	msg[offset] = byte(message.messageType & 0xFF)
-	offset += 1
-	// Message Length (2 bytes) will be assigned at last.
-	msg[offset] = 0
-	offset += 1
-	msg[offset] = 0
-	offset += 1
+	// STUN Message Type
+	binary.BigEndian.PutUint16(msg[offset:], uint16(message.messageType)&0x3FFF)
+	offset += 2
+	// Message Length. NOTE: 20 bytes header not included.
+	binary.BigEndian.PutUint16(msg[offset:], uint16(length-20))
+	offset += 2
 
 	// Magic Cookie
 	binary.BigEndian.PutUint32(msg[offset:], uint32(message.magicCookie))
 	offset += 4
 
+	// Transaction ID
 	copy(msg[offset:], message.transactionId)
 	offset += 12
 
-	if message.mappedAddress != nil {
-		storeEndPoint(MappedAddress, message.mappedAddress, msg, offset)
-		offset += 12
-	} else if message.responseAddress != nil {
-		storeEndPoint(ResponseAddress, message.responseAddress, msg, offset)
-		offset += 12
-	} else if message.changeRequest != nil {
-		/*
-		   The CHANGE-REQUEST attribute is used by the client to request that
-		   the server use a different address and/or port when sending the
-		   response.  The attribute is 32 bits long, although only two bits (A
-		   and B) are used:
-		    0                   1                   2                   3
-		    0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
-		   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-		   |0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 A B 0|
-		   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-		   The meaning of the flags is:
-		   A: This is the "change IP" flag.  If true, it requests the server
-		      to send the Binding Response with a different IP address than the
-		      one the Binding Request was received on.
-		   B: This is the "change port" flag.  If true, it requests the
-		      server to send the Binding Response with a different port than the
-		      one the Binding Request was received on.
-		*/
-
-		// Attribute header
-		binary.BigEndian.PutUint16(msg[offset:], uint16(ChangeRequest))
+	//--- Message attributes ---------------------------------------------
+	for _, attribute := range attributes {
+		// Type
+		binary.BigEndian.PutUint16(msg[offset:], uint16(attribute.Type))
 		offset += 2
-		msg[offset] = 0
-		offset += 1
-		msg[offset] = 4
-		offset += 1
-
-		msg[offset] = 0
-		offset += 1
-		msg[offset] = 0
-		offset += 1
-		msg[offset] = 0
-		offset += 1
-		msg[offset] = func() byte {
-			var value1 byte
-			var value2 byte
-			if message.changeRequest.IsChangeIp() {
-				value1 = 1
-			} else {
-				value1 = 0
-			}
-			value1 <<= 2
-			if message.changeRequest.IsChangePort() {
-				value2 = 1
-			} else {
-				value2 = 0
+		// Length
+		binary.BigEndian.PutUint16(msg[offset:], uint16(len(attribute.Value)))
+		offset += 2
+		// Value
+		copy(msg[offset:], attribute.Value)
+		offset += len(attribute.Value)
+		// Padding
+		padding := paddingLength(len(attribute.Value))
+		if len(attribute.padding) == padding {
+			copy(msg[offset:], attribute.padding)
+		} else {
+			// b may be a reused buffer, so zero the padding explicitly.
+			for i := 0; i < padding; i++ {
+				msg[offset+i] = 0
 			}
-			value2 <<= 1
-			return value2 | value1
-		}()
-		offset += 1
-	} else if message.sourceAddress != nil {
-		storeEndPoint(SourceAddress, message.sourceAddress, msg, offset)
-		offset += 12
-	} else if message.changedAddress != nil {
-		storeEndPoint(ChangedAddress, message.changedAddress, msg, offset)
-		offset += 12
-	} else
-	//        else if (UserName != null)
-	//        {
-	//            var userBytes = Encoding.ASCII.GetBytes(UserName);
-	//
-	//            // Attribute header
-	//            msg[offset] = (int)AttributeType.Username >> 8;
-	//            msg[offset] = (int)AttributeType.Username & 0xFF;
-	//            msg[offset] = (byte)(userBytes.Length >> 8);
-	//            msg[offset] = (byte)(userBytes.Length & 0xFF);
-	//
-	//            Array.Copy(userBytes, 0, msg, offset, userBytes.Length);
-	//            offset += userBytes.Length;
-	//        }
-	//        else if (Password != null)
-	//        {
-	//            var userBytes = Encoding.ASCII.GetBytes(UserName);
-	//
-	//            // Attribute header
-	//            msg[offset] = (int)AttributeType.Password >> 8;
-	//            msg[offset] = (int)AttributeType.Password & 0xFF;
-	//            msg[offset] = (byte)(userBytes.Length >> 8);
-	//            msg[offset] = (byte)(userBytes.Length & 0xFF);
-	//
-	//            Array.Copy(userBytes, 0, msg, offset, userBytes.Length);
-	//            offset += userBytes.Length;
-	//        }
-	if message.errorCode != nil {
-		/* 3489 11.2.9.
-		   0                   1                   2                   3
-		   0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
-		   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-		   |                   0                     |Class|     Number    |
-		   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-		   |      Reason Phrase (variable)                                ..
-		   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-		*/
+		}
+		offset += padding
+	}
+
+	return b
+
+}
+
+// Address families of the address attributes.
+const (
+	FamilyIPv4 = 0x01
+	FamilyIPv6 = 0x02
+)
 
-		reasonBytes := []byte(message.errorCode.GetReasonText())
-
-		// Header
-		msg[offset] = 0
-		offset += 1
-		msg[offset] = byte(ErrorCode)
-		offset += 1
-		msg[offset] = 0
-		offset += 1
-		msg[offset] = byte(4 + len(reasonBytes))
-		offset += 1
-
-		// Empty
-		msg[offset] = 0
-		offset += 1
-		msg[offset] = 0
-		offset += 1
-		// Class
-		msg[offset] = byte(math.Floor(float64(message.errorCode.GetCode()) / 100.0))
-		offset += 1
-		// Number
-		msg[offset] = byte(message.errorCode.GetCode() & 0xFF)
-		offset += 1
-		// ReasonPhrase
-		copy(msg[offset:], reasonBytes)
-		offset += len(reasonBytes)
-	}
-	//        else if (ReflectedFrom != null)
-	//        {
-	//            storeEndPoint(AttributeType.ReflectedFrom, ReflectedFrom, msg, offset);
-	//            offset += 12;
-	//        }
-
-	// Update Message Length. NOTE: 20 bytes header not included.
-	binary.BigEndian.PutUint16(msg[2:], uint16(offset-20))
-
-	return msg[:offset]
-
-}
-
-func storeEndPoint(attributeType AttributeType, endPoint *net.UDPAddr, message []byte, offset int) {
+// Returns the value of an address attribute.
+func storeEndPoint(endPoint *net.UDPAddr) []byte {
 	/*
 	   It consists of an eight bit address family, and a sixteen bit
 	   port, followed by a fixed length value representing the IP address.
+	   The address is 32 bits for IPv4 and 128 bits for IPv6.
 	   0                   1                   2                   3
 	   0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
 	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 	   |x x x x x x x x|    Family     |           Port                |
 	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-	   |                             Address                           |
+	   |                 Address (32 bits or 128 bits)                 |
 	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 	*/
 
-	// Header
-	message[offset] = (byte)(attributeType >> 8)
-	offset += 1
-	message[offset] = (byte)(attributeType & 0xFF)
-	offset += 1
-	message[offset] = 0
-	offset += 1
-	message[offset] = 8
-	offset += 1
+	family := byte(FamilyIPv6)
+	ipBytes := endPoint.IP.To16()
+	if ip4 := endPoint.IP.To4(); ip4 != nil {
+		family = FamilyIPv4
+		ipBytes = ip4
+	}
+
+	value := make([]byte, 4+len(ipBytes))
+	offset := 0
 
 	// Unused
-	message[offset] = 0
+	value[offset] = 0
 	offset += 1
 	// Family
-	message[offset] = 0x01
+	value[offset] = family
 	offset += 1
 	// Port
-	message[offset] = (byte)(endPoint.Port >> 8)
-	offset += 1
-	message[offset] = (byte)(endPoint.Port & 0xFF)
-	offset += 1
+	binary.BigEndian.PutUint16(value[offset:], uint16(endPoint.Port))
+	offset += 2
 	// Address
-	ipBytes := endPoint.IP
-	message[offset] = ipBytes[0]
-	offset += 1
-	message[offset] = ipBytes[1]
-	offset += 1
-	message[offset] = ipBytes[2]
-	offset += 1
-	message[offset] = ipBytes[3]
-	offset += 1
+	copy(value[offset:], ipBytes)
 
-	// offset总共加了12
+	return value
 }
 
 func parseIPAddr(data []byte, offset int) *net.UDPAddr {
 	/*
 	   It consists of an eight bit address family, and a sixteen bit
 	   port, followed by a fixed length value representing the IP address.
+	   The address is 32 bits for IPv4 and 128 bits for IPv6.
 	   0                   1                   2                   3
 	   0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
 	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 	   |x x x x x x x x|    Family     |           Port                |
 	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-	   |                             Address                           |
+	   |                 Address (32 bits or 128 bits)                 |
 	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 	*/
 
-	// Skip family
+	if len(data) < offset+4 {
+		return nil
+	}
+
+	// Skip unused
 	offset++
-	//        System.out.println("地址族 " + data[offset]);
+	// Family
+	var ipLength int
+	switch data[offset] {
+	case FamilyIPv4:
+		ipLength = net.IPv4len
+	case FamilyIPv6:
+		ipLength = net.IPv6len
+	default:
+		return nil
+	}
 	offset++
-	// Port
-	//        int port = data[offsset++] << 8 | data[offset++];
-	//        int port = data[offset++] << 8 | data[offset++];
-
-	//        System.out.println(conver2HexStr(data[offset++]));
-	//        System.out.println(conver2HexStr(data[offset++]));
+	if len(data) < offset+2+ipLength {
+		return nil
+	}
 
+	// Port
 	port := int(binary.BigEndian.Uint16(data[offset : offset+2]))
 	offset += 2
 
-	//        String portStr = conver2HexStr(data[offset++]) + conver2HexStr(data[offset++]);
-	//        int port = Integer.valueOf(portStr, 2);
-	//        System.out.println("parseIPAddr port " + port);
-	//        Long a = Integer.toUnsignedLong(port);
-	//        System.out.println("parseIPAddr port " + a);
-
 	// Address
-	//        byte[] ip = new byte[4];
-	//        System.out.println("ip[0] " + byte2Int(data[offset++]));
-	//        System.out.println("ip[1] " + byte2Int(data[offset++]));
-	//        System.out.println("ip[2] " + byte2Int(data[offset++]));
-	//        System.out.println("ip[3] " + byte2Int(data[offset++]));
-	//        ip[0] = data[offset++];
-	//        ip[1] = data[offset++];
-	//        ip[2] = data[offset++];
-	//        ip[3] = data[offset];
 	return &net.UDPAddr{
-		IP:   data[offset : offset+4],
+		IP:   data[offset : offset+ipLength],
 		Port: port,
 		Zone: "",
 	}
 
 }
+
+func xorIPAddr(addr *net.UDPAddr, magicCookie int, transactionId []byte) *net.UDPAddr {
+	/* RFC 5389 15.2.
+	   X-Port is computed by taking the mapped port in host byte order,
+	   XOR'ing it with the most significant 16 bits of the magic cookie, and
+	   then the converting the result to network byte order.  If the IP
+	   address family is IPv4, X-Address is computed by taking the mapped IP
+	   address in host byte order, XOR'ing it with the magic cookie, and
+	   converting the result to network byte order.  If the IP address
+	   family is IPv6, X-Address is computed by taking the mapped IP address
+	   in host byte order, XOR'ing it with the concatenation of the magic
+	   cookie and the 96-bit transaction ID, and converting the result to
+	   network byte order.
+	*/
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint32(key, uint32(magicCookie))
+	copy(key[4:], transactionId)
+
+	ip := addr.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	xorIP := make(net.IP, len(ip))
+	for i := range ip {
+		xorIP[i] = ip[i] ^ key[i]
+	}
+	return &net.UDPAddr{
+		IP:   xorIP,
+		Port: addr.Port ^ (magicCookie >> 16 & 0xFFFF),
+		Zone: addr.Zone,
+	}
+}
@@ -0,0 +1,162 @@
+package stun
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func decodeSeed(seed string) []byte {
+	data, err := hex.DecodeString(strings.Join(strings.Fields(seed), ""))
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// Parses the packets captured from browsers in the seed corpus of FuzzParse,
+// whose format is a header line and a []byte literal.
+func TestParseCaptures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "fuzz", "FuzzParse", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[1], "[]byte(") {
+			t.Fatalf("%s: not a corpus file", file)
+		}
+		data, err := strconv.Unquote(strings.TrimSuffix(strings.TrimPrefix(lines[1], "[]byte("), ")"))
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		message := NewStunMessage()
+		if err := message.Parse([]byte(data)); err != nil {
+			t.Errorf("%s: Parse() = %v", file, err)
+			continue
+		}
+		if message.GetType() != BindingRequest {
+			t.Errorf("%s: GetType() = %v, want %v", file, message.GetType(), BindingRequest)
+		}
+		if encoded := message.ToByteData(); string(encoded) != data {
+			t.Errorf("%s: ToByteData() = %x, want %x", file, encoded, data)
+		}
+	}
+	if len(files) == 0 {
+		t.Fatal("no captures")
+	}
+}
+
+func TestParseFailureKeepsMessage(t *testing.T) {
+	message := NewStunMessage()
+	if err := message.Parse(decodeSeed(rfc5769IPv4Response)); err != nil {
+		t.Fatal(err)
+	}
+	transactionId := append([]byte(nil), message.GetTransactionId()...)
+
+	// A request whose second attribute, CHANGE-REQUEST, is one byte short.
+	// The SOFTWARE before it is valid.
+	rejected := decodeSeed(`000100102112a4420123456789abcdef01234567
+	8022000462616478
+	0003000300000000`)
+	if err := message.Parse(rejected); !errors.Is(err, ErrInvalidAttributeLength) {
+		t.Fatalf("Parse() = %v, want %v", err, ErrInvalidAttributeLength)
+	}
+	if got := message.GetType(); got != BindingResponse {
+		t.Errorf("GetType() = %v, want %v", got, BindingResponse)
+	}
+	if got := message.GetTransactionId(); !bytes.Equal(got, transactionId) {
+		t.Errorf("GetTransactionId() = %x, want %x", got, transactionId)
+	}
+	if got := message.GetSoftware(); got != "test vector" {
+		t.Errorf("GetSoftware() = %q, want %q", got, "test vector")
+	}
+}
+
+func TestParseRequireFingerprint(t *testing.T) {
+	// The long-term credentials request of RFC 5769 has no FINGERPRINT.
+	data := decodeSeed(rfc5769LongTermRequest)
+	if err := NewStunMessage().Parse(data); err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	if err := NewStunMessage().Parse(data, RequireFingerprint()); err != ErrNoFingerprint {
+		t.Errorf("Parse(RequireFingerprint()) = %v, want %v", err, ErrNoFingerprint)
+	}
+	if err := NewStunMessage().Parse(decodeSeed(rfc5769Request), RequireFingerprint()); err != nil {
+		t.Errorf("Parse(RequireFingerprint()) with FINGERPRINT = %v", err)
+	}
+}
+
+func TestParseManyAttributes(t *testing.T) {
+	// More attributes than Parse collects on the stack.
+	sent := NewStunMessage2(BindingRequest, nil)
+	for i := 0; i < 2*maxLocalAttributes; i++ {
+		sent.AddAttribute(AttributeType(0x8050+i), []byte{byte(i)})
+	}
+	received := NewStunMessage()
+	if err := received.Parse(sent.ToByteData()); err != nil {
+		t.Fatal(err)
+	}
+	if got := received.GetAttributes(); len(got) != 2*maxLocalAttributes || got[len(got)-1].Value[0] != 2*maxLocalAttributes-1 {
+		t.Errorf("GetAttributes() = %v", got)
+	}
+}
+
+func newBenchmarkRequest() *Message {
+	message := NewStunMessage2(BindingRequest, NewStunChangeRequest(true, true))
+	_ = message.SetSoftware("nat-type")
+	message.AddFingerprint()
+	return message
+}
+
+func TestAppendToDecodeDoNotAllocate(t *testing.T) {
+	request := newBenchmarkRequest()
+	buffer := make([]byte, 0, receiveBufferSize)
+	if allocs := testing.AllocsPerRun(100, func() {
+		buffer = request.AppendTo(buffer[:0])
+	}); allocs != 0 {
+		t.Errorf("AppendTo allocates %v times", allocs)
+	}
+
+	data := decodeSeed(rfc5769IPv4Response)
+	message := AcquireMessage()
+	defer ReleaseMessage(message)
+	if allocs := testing.AllocsPerRun(100, func() {
+		if err := message.Decode(data); err != nil {
+			t.Fatal(err)
+		}
+	}); allocs != 0 {
+		t.Errorf("Decode allocates %v times", allocs)
+	}
+}
+
+func BenchmarkMessage_AppendTo(b *testing.B) {
+	request := newBenchmarkRequest()
+	buffer := make([]byte, 0, receiveBufferSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buffer = request.AppendTo(buffer[:0])
+	}
+}
+
+func BenchmarkMessage_Decode(b *testing.B) {
+	data := decodeSeed(rfc5769IPv4Response)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		message := AcquireMessage()
+		if err := message.Decode(data); err != nil {
+			b.Fatal(err)
+		}
+		ReleaseMessage(message)
+	}
+}
@@ -1,11 +1,43 @@
 package stun
 
-type MessageType int
+import "fmt"
+
+// The 14-bit STUN message type, which interleaves a method and a class.
+type MessageType uint16
+
+// The method of a message, e.g. Binding.
+type Method uint16
+
+// The class of a message: request, indication, success or error response.
+type MessageClass uint8
+
+const (
+	ClassRequest         MessageClass = 0x0
+	ClassIndication      MessageClass = 0x1
+	ClassSuccessResponse MessageClass = 0x2
+	ClassErrorResponse   MessageClass = 0x3
+)
+
+const (
+	MethodBinding      Method = 0x001
+	MethodSharedSecret Method = 0x002 // RFC 3489 only
+
+	// RFC 5766 TURN methods.
+	MethodAllocate         Method = 0x003
+	MethodRefresh          Method = 0x004
+	MethodSend             Method = 0x006
+	MethodData             Method = 0x007
+	MethodCreatePermission Method = 0x008
+	MethodChannelBind      Method = 0x009
+)
 
 const (
 	// STUN message is binding request.
 	BindingRequest MessageType = 0x0001
 
+	// STUN message is binding indication.
+	BindingIndication MessageType = 0x0011
+
 	// STUN message is binding request response.
 	BindingResponse MessageType = 0x0101
 
@@ -21,3 +53,70 @@ const (
 	// STUN message is "shared secret" request error response.
 	SharedSecretErrorResponse MessageType = 0x0112
 )
+
+// Composes a message type from a method and a class.
+func NewMessageType(method Method, class MessageClass) MessageType {
+	/* RFC 5389 6.
+	   The message type field is decomposed further into the following
+	   structure:
+	                        0                 1
+	                        2  3  4 5 6 7 8 9 0 1 2 3 4 5
+	                       +--+--+-+-+-+-+-+-+-+-+-+-+-+-+
+	                       |M |M |M|M|M|C|M|M|M|C|M|M|M|M|
+	                       |11|10|9|8|7|1|6|5|4|0|3|2|1|0|
+	                       +--+--+-+-+-+-+-+-+-+-+-+-+-+-+
+	   Here the bits in the message type field are shown as most significant
+	   (M11) through least significant (M0).  M11 through M0 represent a 12-
+	   bit encoding of the method.  C1 and C0 represent a 2-bit encoding of
+	   the class.
+	*/
+	m := uint16(method)
+	c := uint16(class)
+	return MessageType(m&0x000F | (m&0x0070)<<1 | (m&0x0F80)<<2 | (c&0x1)<<4 | (c&0x2)<<7)
+}
+
+func (messageType MessageType) Method() Method {
+	t := uint16(messageType)
+	return Method(t&0x000F | (t&0x00E0)>>1 | (t&0x3E00)>>2)
+}
+
+func (messageType MessageType) Class() MessageClass {
+	t := uint16(messageType)
+	return MessageClass((t&0x0010)>>4 | (t&0x0100)>>7)
+}
+
+var methodNames = map[Method]string{
+	MethodBinding:          "Binding",
+	MethodSharedSecret:     "SharedSecret",
+	MethodAllocate:         "Allocate",
+	MethodRefresh:          "Refresh",
+	MethodSend:             "Send",
+	MethodData:             "Data",
+	MethodCreatePermission: "CreatePermission",
+	MethodChannelBind:      "ChannelBind",
+}
+
+func (method Method) String() string {
+	if name, ok := methodNames[method]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%03X", uint16(method))
+}
+
+var messageClassNames = []string{
+	"Request",
+	"Indication",
+	"SuccessResponse",
+	"ErrorResponse",
+}
+
+func (class MessageClass) String() string {
+	if class <= ClassErrorResponse {
+		return messageClassNames[class]
+	}
+	return ""
+}
+
+func (messageType MessageType) String() string {
+	return messageType.Method().String() + messageType.Class().String()
+}
@@ -0,0 +1,45 @@
+package stun
+
+import "testing"
+
+func TestMessageType(t *testing.T) {
+	tests := []struct {
+		method      Method
+		class       MessageClass
+		messageType MessageType
+	}{
+		{MethodBinding, ClassRequest, BindingRequest},
+		{MethodBinding, ClassIndication, BindingIndication},
+		{MethodBinding, ClassSuccessResponse, BindingResponse},
+		{MethodBinding, ClassErrorResponse, BindingErrorResponse},
+		{MethodSharedSecret, ClassErrorResponse, SharedSecretErrorResponse},
+		// The method bits on either side of C0 and C1.
+		{0x0010, ClassRequest, 0x0020},
+		{0x0080, ClassRequest, 0x0200},
+		{0x0800, ClassSuccessResponse, 0x2100},
+		{0x0FFF, ClassRequest, 0x3EEF},
+		{0x0FFF, ClassIndication, 0x3EFF},
+		{0x0FFF, ClassSuccessResponse, 0x3FEF},
+		{0x0FFF, ClassErrorResponse, 0x3FFF},
+	}
+	for _, test := range tests {
+		if got := NewMessageType(test.method, test.class); got != test.messageType {
+			t.Errorf("NewMessageType(0x%03x, %d) = 0x%04x, want 0x%04x", uint16(test.method), test.class, uint16(got), uint16(test.messageType))
+		}
+		if got := test.messageType.Method(); got != test.method {
+			t.Errorf("0x%04x.Method() = 0x%03x, want 0x%03x", uint16(test.messageType), uint16(got), uint16(test.method))
+		}
+		if got := test.messageType.Class(); got != test.class {
+			t.Errorf("0x%04x.Class() = %d, want %d", uint16(test.messageType), got, test.class)
+		}
+	}
+
+	for method := Method(0); method <= 0x0FFF; method++ {
+		for class := ClassRequest; class <= ClassErrorResponse; class++ {
+			messageType := NewMessageType(method, class)
+			if messageType.Method() != method || messageType.Class() != class {
+				t.Fatalf("0x%03x, %d: 0x%04x decodes to 0x%03x, %d", uint16(method), class, uint16(messageType), uint16(messageType.Method()), messageType.Class())
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package stun
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// Configures a Client, and the package-level functions that create one.
+type ClientOption func(client *Client)
+
+// Sends and receives on socket instead of a new socket bound to the local
+// address for every query. The Client reads socket until Close; it does not
+// close socket.
+func WithSocket(socket *net.UDPConn) ClientOption {
+	return func(client *Client) {
+		client.socket = socket
+	}
+}
+
+// Sets the retransmission schedule of RFC 5389 7.2.1: every request is sent
+// up to rc times, first after the initial rto then doubling the interval, and
+// the transaction fails rm times the RTO after the last send. The RTO then
+// follows the round-trip times measured for each server IP address, but never
+// drops below rto. All three must be positive, or every transaction fails with
+// ErrInvalidRetransmission. Defaults to 500ms, 7 and 16.
+//
+// The whole default schedule lasts 39.5s, so the default 3s of WithTimeout
+// cuts it short after the third send: use WithTimeout(0) to follow it.
+func WithRetransmission(rto time.Duration, rc int, rm int) ClientOption {
+	return func(client *Client) {
+		client.rto = rto
+		client.rc = rc
+		client.rm = rm
+	}
+}
+
+// Gives up on every test after timeout, even if the retransmission schedule
+// is not over; 0 follows the schedule. Defaults to 3s, since a missing
+// response is an expected outcome of several tests.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(client *Client) {
+		client.timeout = timeout
+	}
+}
+
+// Gives up on test after timeout instead of the one set with WithTimeout,
+// e.g. longer on slow mobile links.
+func WithTestTimeout(test Test, timeout time.Duration) ClientOption {
+	return func(client *Client) {
+		client.testTimeouts[test] = timeout
+	}
+}
+
+// Logs every request sent and response received, e.g. with log.Printf.
+func WithLogger(logf func(format string, v ...interface{})) ClientOption {
+	return func(client *Client) {
+		client.logf = logf
+	}
+}
+
+// Runs the transactions on agent, e.g. to share its socket with other
+// Clients or protocols. The Client does not close it.
+func WithAgent(agent *Agent) ClientOption {
+	return func(client *Client) {
+		client.agent = agent
+		client.ownsAgent = false
+	}
+}
+
+// Reads transaction IDs from random instead of crypto/rand, e.g. to produce
+// reproducible packets in tests. Never use a predictable reader in production.
+func WithRandReader(random io.Reader) ClientOption {
+	return func(client *Client) {
+		client.random = random
+	}
+}
+
+// Adds MESSAGE-INTEGRITY computed with key to every request, and discards
+// responses whose MESSAGE-INTEGRITY is missing or does not verify with key.
+// See ShortTermKey and LongTermKey.
+func WithIntegrityKey(key []byte) ClientOption {
+	return func(client *Client) {
+		client.integrityKey = key
+	}
+}
+
+// Authenticates every request with USERNAME and MESSAGE-INTEGRITY using
+// short-term credentials, see WithIntegrityKey.
+func WithShortTermCredentials(username string, password string) ClientOption {
+	return func(client *Client) {
+		client.username = username
+		client.integrityKey = ShortTermKey(password)
+	}
+}
+
+// Appends FINGERPRINT to every request, for servers that share their port
+// with other protocols.
+func WithAlwaysFingerprint() ClientOption {
+	return func(client *Client) {
+		client.fingerprint = true
+	}
+}
+
+// Bounds the idle intervals MeasureLifetime tries. It reports 0 when the
+// mapping does not outlive min, and max when it outlives max. min must be
+// positive and max at least min.
+func WithLifetimeBounds(min time.Duration, max time.Duration) ClientOption {
+	return func(client *Client) {
+		client.lifetimeMin = min
+		client.lifetimeMax = max
+	}
+}
+
+// Stops the binary search of MeasureLifetime once the lifetime is known
+// within precision, which must be positive.
+func WithLifetimePrecision(precision time.Duration) ClientOption {
+	return func(client *Client) {
+		client.lifetimePrecision = precision
+	}
+}
+
+// Calls progress after every probe of MeasureLifetime.
+func WithLifetimeProgress(progress func(progress LifetimeProgress)) ClientOption {
+	return func(client *Client) {
+		client.lifetimeProgress = progress
+	}
+}
+
+func (client *Client) newMessage(messageType MessageType, changeRequest *Request, extra ...MessageOption) (*Message, error) {
+	opts := append([]MessageOption{WithTransactionIdFrom(client.random)}, extra...)
+	if changeRequest != nil {
+		opts = append(opts, WithChangeRequest(changeRequest.IsChangeIp(), changeRequest.IsChangePort()))
+	}
+	if client.username != "" {
+		opts = append(opts, WithUsername(client.username))
+	}
+	if client.integrityKey != nil {
+		opts = append(opts, WithMessageIntegrity(client.integrityKey))
+	}
+	if client.fingerprint {
+		opts = append(opts, WithFingerprint())
+	}
+	return Build(messageType, opts...)
+}
+
+type parseConfig struct {
+	requireFingerprint bool
+}
+
+// Configures Message.Parse.
+type ParseOption func(config *parseConfig)
+
+// Rejects messages without FINGERPRINT, e.g. on a server that shares its
+// port with other protocols and must not take their packets for STUN.
+func RequireFingerprint() ParseOption {
+	return func(config *parseConfig) {
+		config.requireFingerprint = true
+	}
+}
+
+func newParseConfig(opts []ParseOption) *parseConfig {
+	config := &parseConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
@@ -0,0 +1,42 @@
+package stun
+
+import "sync"
+
+var messagePool = sync.Pool{
+	New: func() interface{} {
+		return &Message{}
+	},
+}
+
+// Returns an empty message from the pool. Release it with ReleaseMessage once
+// neither it nor anything returned by its getters is in use any more.
+func AcquireMessage() *Message {
+	return messagePool.Get().(*Message)
+}
+
+// Resets the message and puts it back into the pool.
+func ReleaseMessage(message *Message) {
+	message.Reset()
+	messagePool.Put(message)
+}
+
+// Empties the message, keeping the capacity of its attribute list.
+func (message *Message) Reset() {
+	for i := range message.attributes {
+		message.attributes[i] = Attribute{}
+	}
+	message.attributes = message.attributes[:0]
+	message.transactionId = nil
+	message.messageType = 0
+	message.magicCookie = 0
+}
+
+// Size of the receive buffers: a STUN message never exceeds the path MTU.
+const receiveBufferSize = 1500
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, receiveBufferSize)
+		return &buffer
+	},
+}
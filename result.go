@@ -3,8 +3,9 @@ package stun
 import "net"
 
 type Result struct {
-	ipAddr  net.IP
-	natType NatType
+	ipAddr      net.IP
+	natType     NatType
+	hairpinning HairpinningBehavior
 }
 
 func (result Result) GetNatType() NatType {
@@ -15,9 +16,47 @@ func (result Result) GetIpAddr() net.IP {
 	return result.ipAddr
 }
 
+// Whether peers behind the same NAT can reach each other through their
+// mappings. HairpinningUnknown when there is no NAT or the test did not run.
+func (result Result) GetHairpinning() HairpinningBehavior {
+	return result.hairpinning
+}
+
 func NewStunResult(natType NatType, ipAddr net.IP) *Result {
+	return NewStunResult1(natType, ipAddr, HairpinningUnknown)
+}
+
+func NewStunResult1(natType NatType, ipAddr net.IP, hairpinning HairpinningBehavior) *Result {
 	return &Result{
-		natType: natType,
-		ipAddr:  ipAddr,
+		natType:     natType,
+		ipAddr:      ipAddr,
+		hairpinning: hairpinning,
+	}
+}
+
+// The result of DiscoverBehavior: mapping and filtering reported separately.
+type BehaviorResult struct {
+	mappedAddress *net.UDPAddr
+	mapping       MappingBehavior
+	filtering     FilteringBehavior
+}
+
+func (result BehaviorResult) GetMappedAddress() *net.UDPAddr {
+	return result.mappedAddress
+}
+
+func (result BehaviorResult) GetMappingBehavior() MappingBehavior {
+	return result.mapping
+}
+
+func (result BehaviorResult) GetFilteringBehavior() FilteringBehavior {
+	return result.filtering
+}
+
+func NewStunBehaviorResult(mappedAddress *net.UDPAddr, mapping MappingBehavior, filtering FilteringBehavior) *BehaviorResult {
+	return &BehaviorResult{
+		mappedAddress: mappedAddress,
+		mapping:       mapping,
+		filtering:     filtering,
 	}
 }
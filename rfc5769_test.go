@@ -0,0 +1,190 @@
+package stun
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+// Test vectors from RFC 5769, "Test Vectors for Session Traversal Utilities
+// for NAT (STUN)".
+const (
+	// 2.1. Sample Request
+	rfc5769Request = `000100582112a442b7e7a701bc34d686fa87dfae
+	802200105354554e207465737420636c69656e74
+	002400046e0001ff
+	80290008932ff9b151263b36
+	000600096576746a3a68367659202020
+	000800149aeaa70cbfd8cb56781ef2b5b2d3f249c1b571a2
+	80280004e57a3bcf`
+
+	// 2.2. Sample IPv4 Response
+	rfc5769IPv4Response = `0101003c2112a442b7e7a701bc34d686fa87dfae
+	8022000b7465737420766563746f7220
+	002000080001a147e112a643
+	000800142b91f599fd9e90c38c7489f92af9ba53f06be7d7
+	80280004c07d4c96`
+
+	// 2.3. Sample IPv6 Response
+	rfc5769IPv6Response = `010100482112a442b7e7a701bc34d686fa87dfae
+	8022000b7465737420766563746f7220
+	002000140002a1470113a9faa5d3f179bc25f4b5bed2b9d9
+	00080014a382954e4be67bf11784c97c8292c275bfe3ed41
+	80280004c8fb0b4c`
+
+	// 2.4. Sample Request with Long-Term Authentication
+	rfc5769LongTermRequest = `000100602112a44278ad3433c6ad72c029da412e
+	00060012e3839ee38388e383aae38383e382afe382b90000
+	0015001c662f2f3439396b39353464364f4c33346f4c394653547679363473410014000b6578616d706c652e6f726700
+	00080014f67024656dd64a3e02b8e0712e85c9a28ca89666`
+)
+
+const (
+	rfc5769Password         = "VOkJxbRl1RmTxUk/WvJxBt"
+	rfc5769LongTermUsername = "マトリックス"
+	// "The<U+00AD>M<U+00AA>trIX" after SASLprep.
+	rfc5769LongTermPassword = "TheMatrIX"
+	rfc5769Nonce            = "f//499k954d6OL34oL9FSTvy64sA"
+	rfc5769Realm            = "example.org"
+)
+
+var rfc5769Vectors = []struct {
+	name             string
+	data             string
+	key              []byte
+	messageType      MessageType
+	software         string
+	username         string
+	xorMappedAddress *net.UDPAddr
+	fingerprint      bool
+}{
+	{
+		name:        "Request",
+		data:        rfc5769Request,
+		key:         ShortTermKey(rfc5769Password),
+		messageType: BindingRequest,
+		software:    "STUN test client",
+		username:    "evtj:h6vY",
+		fingerprint: true,
+	},
+	{
+		name:             "IPv4Response",
+		data:             rfc5769IPv4Response,
+		key:              ShortTermKey(rfc5769Password),
+		messageType:      BindingResponse,
+		software:         "test vector",
+		xorMappedAddress: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 32853},
+		fingerprint:      true,
+	},
+	{
+		name:             "IPv6Response",
+		data:             rfc5769IPv6Response,
+		key:              ShortTermKey(rfc5769Password),
+		messageType:      BindingResponse,
+		software:         "test vector",
+		xorMappedAddress: &net.UDPAddr{IP: net.ParseIP("2001:db8:1234:5678:11:2233:4455:6677"), Port: 32853},
+		fingerprint:      true,
+	},
+	{
+		name:        "LongTermRequest",
+		data:        rfc5769LongTermRequest,
+		key:         LongTermKey(rfc5769LongTermUsername, rfc5769Realm, rfc5769LongTermPassword),
+		messageType: BindingRequest,
+		username:    rfc5769LongTermUsername,
+	},
+}
+
+func TestRFC5769(t *testing.T) {
+	for _, vector := range rfc5769Vectors {
+		t.Run(vector.name, func(t *testing.T) {
+			data := decodeSeed(vector.data)
+			var opts []ParseOption
+			if vector.fingerprint {
+				opts = append(opts, RequireFingerprint())
+			}
+			message := &Message{}
+			if err := message.Parse(data, opts...); err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+
+			if got := message.GetType(); got != vector.messageType {
+				t.Errorf("GetType() = %v, want %v", got, vector.messageType)
+			}
+			if got := message.GetSoftware(); got != vector.software {
+				t.Errorf("GetSoftware() = %q, want %q", got, vector.software)
+			}
+			if got := message.GetUsername(); got != vector.username {
+				t.Errorf("GetUsername() = %q, want %q", got, vector.username)
+			}
+			if got := message.GetXorMappedAddress(); vector.xorMappedAddress != nil &&
+				(got == nil || !got.IP.Equal(vector.xorMappedAddress.IP) || got.Port != vector.xorMappedAddress.Port) {
+				t.Errorf("GetXorMappedAddress() = %v, want %v", got, vector.xorMappedAddress)
+			}
+			if got := message.HasFingerprint(); got != vector.fingerprint {
+				t.Errorf("HasFingerprint() = %v, want %v", got, vector.fingerprint)
+			}
+
+			if err := message.Verify(vector.key); err != nil {
+				t.Errorf("Verify() = %v", err)
+			}
+			if err := message.Verify([]byte("wrong")); !errors.Is(err, ErrIntegrityMismatch) {
+				t.Errorf("Verify(wrong key) = %v, want %v", err, ErrIntegrityMismatch)
+			}
+
+			if encoded := message.ToByteData(); !bytes.Equal(encoded, data) {
+				t.Errorf("ToByteData() = %x, want %x", encoded, data)
+			}
+
+			// Recomputing MESSAGE-INTEGRITY and FINGERPRINT gives the same bytes.
+			message.AddMessageIntegrity(vector.key)
+			if vector.fingerprint {
+				message.AddFingerprint()
+			}
+			if encoded := message.ToByteData(); !bytes.Equal(encoded, data) {
+				t.Errorf("ToByteData() after re-signing = %x, want %x", encoded, data)
+			}
+		})
+	}
+}
+
+func TestRFC5769Tampered(t *testing.T) {
+	for _, vector := range rfc5769Vectors {
+		t.Run(vector.name, func(t *testing.T) {
+			data := decodeSeed(vector.data)
+			// Flip a bit of the transaction ID, which both checks cover.
+			data[8] ^= 1
+			message := &Message{}
+			err := message.Parse(data)
+			if vector.fingerprint {
+				if !errors.Is(err, ErrFingerprintMismatch) {
+					t.Fatalf("Parse() = %v, want %v", err, ErrFingerprintMismatch)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+			if err := message.Verify(vector.key); !errors.Is(err, ErrIntegrityMismatch) {
+				t.Errorf("Verify() = %v, want %v", err, ErrIntegrityMismatch)
+			}
+		})
+	}
+}
+
+func TestRFC5769BuildLongTermRequest(t *testing.T) {
+	data := decodeSeed(rfc5769LongTermRequest)
+	message, err := Build(BindingRequest,
+		WithTransactionId(data[8:20]),
+		WithUsername(rfc5769LongTermUsername),
+		WithNonce(rfc5769Nonce),
+		WithRealm(rfc5769Realm),
+		WithMessageIntegrity(LongTermKey(rfc5769LongTermUsername, rfc5769Realm, rfc5769LongTermPassword)),
+	)
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	if encoded := message.ToByteData(); !bytes.Equal(encoded, data) {
+		t.Errorf("ToByteData() = %x, want %x", encoded, data)
+	}
+}
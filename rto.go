@@ -0,0 +1,149 @@
+package stun
+
+import (
+	"net"
+	"time"
+)
+
+// RFC 5389 7.2.1 defaults.
+const (
+	defaultRto = 500 * time.Millisecond
+	defaultRc  = 7
+	defaultRm  = 16
+
+	// The clock granularity G of RFC 2988.
+	clockGranularity = 10 * time.Millisecond
+
+	// RFC 5389 7.2.1: the RTO is cached for a server until no transaction
+	// was made with it for this long.
+	rtoCacheDuration = 10 * time.Minute
+)
+
+// The retransmission schedule of one transaction, RFC 5389 7.2.1: the
+// request is sent up to Rc times, the interval doubling from RTO, and the
+// transaction fails Rm times RTO after the last send, or once the timeout of
+// the test has passed.
+type schedule struct {
+	rto      time.Duration
+	last     time.Duration // how long to wait after the last send
+	left     int           // sends left
+	next     time.Time     // when to send again, or to give up once left is 0
+	deadline time.Time     // zero if the test has no timeout
+}
+
+func (client *Client) newSchedule(test Test, remoteEndPoint net.Addr, now time.Time) *schedule {
+	return newSchedule(client.currentRto(remoteEndPoint, now), client.rc, client.rm, client.testTimeout(test), now)
+}
+
+func newSchedule(rto time.Duration, rc int, rm int, timeout time.Duration, now time.Time) *schedule {
+	s := &schedule{
+		rto:  rto,
+		last: time.Duration(rm) * rto,
+		left: rc,
+		next: now,
+	}
+	if timeout > 0 {
+		s.deadline = now.Add(timeout)
+	}
+	return s
+}
+
+// Tells whether to send the request at now, and false ok once the
+// transaction has timed out.
+func (s *schedule) tick(now time.Time) (send bool, ok bool) {
+	if !s.deadline.IsZero() && !now.Before(s.deadline) {
+		return false, false
+	}
+	if now.Before(s.next) {
+		return false, true
+	}
+	if s.left == 0 {
+		return false, false
+	}
+	s.left--
+	if s.left == 0 {
+		s.next = now.Add(s.last)
+	} else {
+		s.next = now.Add(s.rto)
+		s.rto *= 2
+	}
+	return true, true
+}
+
+// Until when to wait for a response before the next tick.
+func (s *schedule) wait() time.Time {
+	if !s.deadline.IsZero() && s.deadline.Before(s.next) {
+		return s.deadline
+	}
+	return s.next
+}
+
+// The RTO for one server IP address, from the round-trip times measured as
+// described in RFC 2988 2.
+type rtoEstimate struct {
+	rto    time.Duration
+	srtt   time.Duration
+	rttvar time.Duration
+	used   time.Time
+}
+
+// Feeds a round-trip time into the estimate. The RTO never drops below
+// minRto, so that tests which expect no response do not give up too early.
+func (estimate *rtoEstimate) sample(rtt time.Duration, minRto time.Duration) {
+	if estimate.srtt == 0 {
+		estimate.srtt = rtt
+		estimate.rttvar = rtt / 2
+	} else {
+		delta := estimate.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		estimate.rttvar = (3*estimate.rttvar + delta) / 4
+		estimate.srtt = (7*estimate.srtt + rtt) / 8
+	}
+	variance := 4 * estimate.rttvar
+	if variance < clockGranularity {
+		variance = clockGranularity
+	}
+	estimate.rto = estimate.srtt + variance
+	if estimate.rto < minRto {
+		estimate.rto = minRto
+	}
+}
+
+func rtoKey(remoteEndPoint net.Addr) string {
+	if addr, ok := remoteEndPoint.(*net.UDPAddr); ok {
+		return addr.IP.String()
+	}
+	return remoteEndPoint.String()
+}
+
+// The cached RTO for the IP address of remoteEndPoint, or the initial one if
+// none was measured in the last rtoCacheDuration.
+func (client *Client) currentRto(remoteEndPoint net.Addr, now time.Time) time.Duration {
+	client.rtoMutex.Lock()
+	defer client.rtoMutex.Unlock()
+	estimate, ok := client.rtos[rtoKey(remoteEndPoint)]
+	if !ok || now.Sub(estimate.used) > rtoCacheDuration {
+		return client.rto
+	}
+	estimate.used = now
+	return estimate.rto
+}
+
+// Feeds a round-trip time to remoteEndPoint into its RTO. By Karn's
+// algorithm, only responses to requests that were sent once are sampled,
+// since those to retransmitted ones cannot be told apart.
+func (client *Client) sampleRtt(remoteEndPoint net.Addr, rtt time.Duration) {
+	client.rtoMutex.Lock()
+	defer client.rtoMutex.Unlock()
+	key := rtoKey(remoteEndPoint)
+	now := time.Now()
+	estimate, ok := client.rtos[key]
+	if !ok || now.Sub(estimate.used) > rtoCacheDuration {
+		estimate = &rtoEstimate{}
+		client.rtos[key] = estimate
+	}
+	estimate.used = now
+	estimate.sample(rtt, client.rto)
+}
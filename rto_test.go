@@ -0,0 +1,131 @@
+package stun
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// Runs a schedule to its end, ticking whenever it waits, and returns the
+// offsets of the sends and of the give-up.
+func runSchedule(s *schedule, start time.Time) (sends []time.Duration, end time.Duration) {
+	now := start
+	for {
+		send, ok := s.tick(now)
+		if !ok {
+			return sends, now.Sub(start)
+		}
+		if send {
+			sends = append(sends, now.Sub(start))
+		}
+		now = s.wait()
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		rto     time.Duration
+		rc, rm  int
+		timeout time.Duration
+		sends   []time.Duration
+		end     time.Duration
+	}{
+		// The example of RFC 5389 7.2.1.
+		{"rfc5389", 500 * ms, 7, 16, 0, []time.Duration{0, 500 * ms, 1500 * ms, 3500 * ms, 7500 * ms, 15500 * ms, 31500 * ms}, 39500 * ms},
+		{"timeout", 500 * ms, 7, 16, 3 * time.Second, []time.Duration{0, 500 * ms, 1500 * ms}, 3 * time.Second},
+		{"single send", 100 * ms, 1, 4, 0, []time.Duration{0}, 400 * ms},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := time.Unix(1000, 0)
+			sends, end := runSchedule(newSchedule(test.rto, test.rc, test.rm, test.timeout, start), start)
+			if len(sends) != len(test.sends) {
+				t.Fatalf("got sends at %v, want %v", sends, test.sends)
+			}
+			for i := range sends {
+				if sends[i] != test.sends[i] {
+					t.Errorf("got sends at %v, want %v", sends, test.sends)
+					break
+				}
+			}
+			if end != test.end {
+				t.Errorf("gave up after %v, want %v", end, test.end)
+			}
+		})
+	}
+}
+
+func TestScheduleWaitsUntilDeadline(t *testing.T) {
+	start := time.Unix(1000, 0)
+	s := newSchedule(time.Second, 7, 16, 300*time.Millisecond, start)
+	if send, ok := s.tick(start); !send || !ok {
+		t.Fatalf("first tick: got send %v ok %v, want true true", send, ok)
+	}
+	if got, want := s.wait(), start.Add(300*time.Millisecond); !got.Equal(want) {
+		t.Errorf("wait: got %v, want the deadline %v", got, want)
+	}
+	if send, ok := s.tick(start.Add(100 * time.Millisecond)); send || !ok {
+		t.Errorf("early tick: got send %v ok %v, want false true", send, ok)
+	}
+}
+
+func TestRtoEstimateSample(t *testing.T) {
+	ms := time.Millisecond
+	var estimate rtoEstimate
+	estimate.sample(100*ms, 0)
+	if estimate.srtt != 100*ms || estimate.rttvar != 50*ms || estimate.rto != 300*ms {
+		t.Errorf("first sample: got srtt %v rttvar %v rto %v, want 100ms 50ms 300ms", estimate.srtt, estimate.rttvar, estimate.rto)
+	}
+	estimate.sample(200*ms, 0)
+	if estimate.srtt != 112500*time.Microsecond || estimate.rttvar != 62500*time.Microsecond || estimate.rto != 362500*time.Microsecond {
+		t.Errorf("second sample: got srtt %v rttvar %v rto %v, want 112.5ms 62.5ms 362.5ms", estimate.srtt, estimate.rttvar, estimate.rto)
+	}
+
+	// The clock granularity bounds the variance, the minimum the RTO.
+	estimate = rtoEstimate{}
+	estimate.sample(ms, 0)
+	if estimate.rto != ms+clockGranularity {
+		t.Errorf("granularity: got rto %v, want %v", estimate.rto, ms+clockGranularity)
+	}
+	estimate.sample(ms, defaultRto)
+	if estimate.rto != defaultRto {
+		t.Errorf("minimum: got rto %v, want %v", estimate.rto, defaultRto)
+	}
+}
+
+func TestClientRtoPerServer(t *testing.T) {
+	client := NewClient2(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3478}, &net.UDPAddr{}, WithRetransmission(100*time.Millisecond, 7, 16))
+	primary := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3478}
+	alternate := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 3479}
+
+	client.sampleRtt(primary, time.Second)
+	now := time.Now()
+	if got := client.currentRto(&net.UDPAddr{IP: primary.IP, Port: 3479}, now); got != 3*time.Second {
+		t.Errorf("primary IP: got rto %v, want 3s", got)
+	}
+	if got := client.currentRto(alternate, now); got != 100*time.Millisecond {
+		t.Errorf("alternate IP: got rto %v, want the initial 100ms", got)
+	}
+	if got := client.currentRto(primary, now.Add(rtoCacheDuration+time.Second)); got != 100*time.Millisecond {
+		t.Errorf("expired: got rto %v, want the initial 100ms", got)
+	}
+}
+
+func TestInvalidRetransmission(t *testing.T) {
+	server := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3478}
+	for _, opt := range []ClientOption{
+		WithRetransmission(0, 7, 16),
+		WithRetransmission(500*time.Millisecond, 0, 16),
+		WithRetransmission(500*time.Millisecond, 7, -1),
+	} {
+		client := NewClient2(server, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, opt)
+		result, err := client.Query(context.Background())
+		if !errors.Is(err, ErrInvalidRetransmission) {
+			t.Errorf("rto %v, rc %d, rm %d: got %v, %v, want %v", client.rto, client.rc, client.rm, result, err, ErrInvalidRetransmission)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package stun
+
+import "unicode/utf8"
+
+// RFC 5389 15.3, 15.7, 15.8 and 15.10 limit string attributes both in
+// characters and in bytes. 0 means no limit on characters.
+var stringAttributeLimits = map[AttributeType]struct {
+	characters int
+	bytes      int
+}{
+	Username: {0, 512},
+	Realm:    {127, 763},
+	Nonce:    {127, 763},
+	Software: {127, 763},
+}
+
+func (message *Message) getString(attributeType AttributeType) string {
+	if attribute, ok := message.GetAttribute(attributeType); ok {
+		return string(attribute.Value)
+	}
+	return ""
+}
+
+// Sets a UTF-8 string attribute, or removes it if value is empty.
+func (message *Message) setString(attributeType AttributeType, value string) error {
+	if value == "" {
+		message.RemoveAttribute(attributeType)
+		return nil
+	}
+	if !utf8.ValidString(value) {
+		return ErrInvalidUTF8
+	}
+	if limits, ok := stringAttributeLimits[attributeType]; ok {
+		if len(value) > limits.bytes || limits.characters > 0 && utf8.RuneCountInString(value) > limits.characters {
+			return ErrAttributeTooLong
+		}
+	} else if len(value) > 0xFFFF {
+		return ErrAttributeTooLong
+	}
+	message.SetAttribute(attributeType, []byte(value))
+	return nil
+}
+
+func (message *Message) GetUsername() string {
+	return message.getString(Username)
+}
+
+func (message *Message) SetUsername(username string) error {
+	return message.setString(Username, username)
+}
+
+// PASSWORD only exists in RFC 3489 Shared Secret Responses.
+func (message *Message) GetPassword() string {
+	return message.getString(Password)
+}
+
+func (message *Message) SetPassword(password string) error {
+	return message.setString(Password, password)
+}
+
+func (message *Message) GetRealm() string {
+	return message.getString(Realm)
+}
+
+func (message *Message) SetRealm(realm string) error {
+	return message.setString(Realm, realm)
+}
+
+func (message *Message) GetNonce() string {
+	return message.getString(Nonce)
+}
+
+func (message *Message) SetNonce(nonce string) error {
+	return message.setString(Nonce, nonce)
+}
+
+// Returns the SOFTWARE attribute, which identifies the implementation of the
+// agent that sent the message.
+func (message *Message) GetSoftware() string {
+	return message.getString(Software)
+}
+
+func (message *Message) SetSoftware(software string) error {
+	return message.setString(Software, software)
+}
@@ -0,0 +1,89 @@
+package stun
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStringAttributeLimits(t *testing.T) {
+	type stringTest struct {
+		attributeType AttributeType
+		value         string
+		err           error
+	}
+	tests := []stringTest{
+		{Username, strings.Repeat("a", 512), nil},
+		{Username, strings.Repeat("a", 513), ErrAttributeTooLong},
+		// USERNAME is limited in bytes only.
+		{Username, strings.Repeat("é", 256), nil},
+		{Username, strings.Repeat("é", 257), ErrAttributeTooLong},
+		{Username, "\xff", ErrInvalidUTF8},
+		{Password, strings.Repeat("a", 0xFFFF), nil},
+		{Password, strings.Repeat("a", 0x10000), ErrAttributeTooLong},
+	}
+	// 127 characters of at most 4 bytes never reach 763 bytes, so only the
+	// character limit can be hit by valid UTF-8.
+	for _, attributeType := range []AttributeType{Realm, Nonce, Software} {
+		tests = append(tests, []stringTest{
+			{attributeType, strings.Repeat("a", 127), nil},
+			{attributeType, strings.Repeat("a", 128), ErrAttributeTooLong},
+			{attributeType, strings.Repeat("😀", 127), nil},
+			{attributeType, strings.Repeat("😀", 128), ErrAttributeTooLong},
+			{attributeType, "a\xc3", ErrInvalidUTF8},
+		}...)
+	}
+	for _, test := range tests {
+		message := NewStunMessage2(BindingRequest, nil)
+		err := message.setString(test.attributeType, test.value)
+		if err != test.err {
+			t.Errorf("%v of %d bytes: got %v, want %v", test.attributeType, len(test.value), err, test.err)
+		}
+		if got := message.getString(test.attributeType); (got == test.value) != (test.err == nil) {
+			t.Errorf("%v of %d bytes: got %d bytes set", test.attributeType, len(test.value), len(got))
+		}
+	}
+}
+
+func TestStringAttributeRemove(t *testing.T) {
+	message := NewStunMessage2(BindingRequest, nil)
+	if err := message.SetSoftware("nat-type"); err != nil {
+		t.Fatal(err)
+	}
+	if err := message.SetSoftware(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := message.GetAttribute(Software); ok {
+		t.Error("SOFTWARE not removed")
+	}
+}
+
+func TestParseStringAttributeLimits(t *testing.T) {
+	tests := []struct {
+		attributeType AttributeType
+		length        int
+		err           error
+	}{
+		{Username, 512, nil},
+		{Username, 513, ErrInvalidAttributeLength},
+		{Realm, 763, nil},
+		{Realm, 764, ErrInvalidAttributeLength},
+		{Nonce, 763, nil},
+		{Nonce, 764, ErrInvalidAttributeLength},
+		{Software, 763, nil},
+		{Software, 764, ErrInvalidAttributeLength},
+	}
+	for _, test := range tests {
+		// AddAttribute does not check the limits, like a remote agent.
+		sent := NewStunMessage2(BindingRequest, nil)
+		sent.AddAttribute(test.attributeType, []byte(strings.Repeat("a", test.length)))
+		err := NewStunMessage().Parse(sent.ToByteData())
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v of %d bytes: got %v, want %v", test.attributeType, test.length, err, test.err)
+		}
+		var attributeError *AttributeError
+		if err != nil && (!errors.As(err, &attributeError) || attributeError.Type != test.attributeType) {
+			t.Errorf("%v of %d bytes: got %v, want an *AttributeError for it", test.attributeType, test.length, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package stun
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Attributes 0x0000-0x7FFF are comprehension-required: an agent that does not
+// understand one must reject the message. 0x8000-0xFFFF may be ignored.
+func (t AttributeType) IsComprehensionRequired() bool {
+	return t < 0x8000
+}
+
+// The attribute types this package understands. Unlike attributeTypeNames, it
+// leaves out the reserved 0x0000 and the obsolete PASSWORD and XOR-ONLY, which
+// have names but no meaning to us.
+var comprehendedAttributeTypes = map[AttributeType]bool{
+	MappedAddress:    true,
+	ResponseAddress:  true,
+	ChangeRequest:    true,
+	SourceAddress:    true,
+	ChangedAddress:   true,
+	Username:         true,
+	MessageIntegrity: true,
+	ErrorCode:        true,
+	UnknownAttribute: true,
+	ReflectedFrom:    true,
+	Realm:            true,
+	Nonce:            true,
+	XorMappedAddress: true,
+	ResponsePort:     true,
+	Software:         true,
+	Fingerprint:      true,
+	ResponseOrigin:   true,
+	OtherAddress:     true,
+}
+
+// Reports whether this package understands the attribute type.
+func (t AttributeType) IsKnown() bool {
+	return comprehendedAttributeTypes[t]
+}
+
+// Returns the attribute types listed in UNKNOWN-ATTRIBUTES.
+func (message *Message) GetUnknownAttributes() []AttributeType {
+	/* RFC 5389 15.9.
+	   The attribute contains a list of 16-bit values, each of which
+	   represents an attribute type that was not understood by the server.
+	    0                   1                   2                   3
+	    0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	   |      Attribute 1 Type           |     Attribute 2 Type        |
+	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	   |      Attribute 3 Type           |     Attribute 4 Type    ...
+	   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	*/
+	attribute, ok := message.GetAttribute(UnknownAttribute)
+	if !ok {
+		return nil
+	}
+	types := make([]AttributeType, 0, len(attribute.Value)/2)
+	for offset := 0; offset+2 <= len(attribute.Value); offset += 2 {
+		types = append(types, AttributeType(binary.BigEndian.Uint16(attribute.Value[offset:])))
+	}
+	return types
+}
+
+func (message *Message) SetUnknownAttributes(types []AttributeType) {
+	if len(types) == 0 {
+		message.RemoveAttribute(UnknownAttribute)
+		return
+	}
+	value := make([]byte, 2*len(types))
+	for i, t := range types {
+		binary.BigEndian.PutUint16(value[2*i:], uint16(t))
+	}
+	message.SetAttribute(UnknownAttribute, value)
+}
+
+// Returns the comprehension-required attributes of the message this package
+// does not understand. A server must answer a request carrying any with 420,
+// see NewStunUnknownAttributesResponse.
+func (message *Message) GetUnknownComprehensionRequired() []AttributeType {
+	var types []AttributeType
+	for _, attribute := range message.attributes {
+		if attribute.Type.IsComprehensionRequired() && !attribute.Type.IsKnown() {
+			types = append(types, attribute.Type)
+		}
+	}
+	return types
+}
+
+// Returns the 420 (Unknown Attribute) error response to request, listing the
+// attribute types that were not understood.
+func NewStunUnknownAttributesResponse(request *Message, types []AttributeType) *Message {
+	response := &Message{
+		messageType:   NewMessageType(request.messageType.Method(), ClassErrorResponse),
+		magicCookie:   request.magicCookie,
+		transactionId: append([]byte(nil), request.transactionId...),
+	}
+	response.SetAttribute(ErrorCode, CodeUnknownAttribute.toByteData())
+	response.SetUnknownAttributes(types)
+	return response
+}
+
+// Returned by the client for a 420 (Unknown Attribute) error response. It
+// unwraps to the error code, so errors.Is(err, CodeUnknownAttribute) holds.
+type UnknownAttributesError struct {
+	Code  *Code
+	Types []AttributeType
+}
+
+func (e *UnknownAttributesError) Error() string {
+	return fmt.Sprintf("%v %v", e.Code, e.Types)
+}
+
+func (e *UnknownAttributesError) Unwrap() error {
+	return e.Code
+}
@@ -0,0 +1,62 @@
+package stun
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestIsKnown(t *testing.T) {
+	for _, known := range []AttributeType{MappedAddress, XorMappedAddress, ErrorCode, Software, Fingerprint, OtherAddress} {
+		if !known.IsKnown() {
+			t.Errorf("%v.IsKnown() = false", known)
+		}
+	}
+	// Named, but not understood.
+	for _, unknown := range []AttributeType{Undefined, Password, XorOnly, 0x0030, 0x8050} {
+		if unknown.IsKnown() {
+			t.Errorf("%v.IsKnown() = true", unknown)
+		}
+	}
+}
+
+func TestGetUnknownComprehensionRequired(t *testing.T) {
+	// A request with SOFTWARE, XOR-ONLY, the unassigned 0x0030 and 0x8050,
+	// and USERNAME. 0x8050 may be ignored.
+	request := NewStunMessage()
+	err := request.Parse(decodeSeed(`000100242112a4420123456789abcdef01234567
+	8022000462616478
+	00210000
+	0030000400000000
+	8050000400000000
+	0006000475736572`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AttributeType{XorOnly, 0x0030}
+	got := request.GetUnknownComprehensionRequired()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetUnknownComprehensionRequired() = %v, want %v", got, want)
+	}
+
+	response := NewStunUnknownAttributesResponse(request, got)
+	if response.GetType() != BindingErrorResponse {
+		t.Errorf("GetType() = %v, want %v", response.GetType(), BindingErrorResponse)
+	}
+	if !bytes.Equal(response.GetTransactionId(), request.GetTransactionId()) {
+		t.Errorf("GetTransactionId() = %x, want %x", response.GetTransactionId(), request.GetTransactionId())
+	}
+
+	// What the client of the server gets.
+	received := NewStunMessage()
+	if err := received.Parse(response.ToByteData()); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(received.GetErrorCode(), CodeUnknownAttribute) {
+		t.Errorf("GetErrorCode() = %v, want %v", received.GetErrorCode(), CodeUnknownAttribute)
+	}
+	if got := received.GetUnknownAttributes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetUnknownAttributes() = %v, want %v", got, want)
+	}
+}